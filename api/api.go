@@ -2,69 +2,172 @@ package api
 
 import (
 	"net/http"
+	"resource-manager/audit"
 	"resource-manager/config"
 	"resource-manager/kube"
+	"resource-manager/metrics"
 	"resource-manager/processing"
 	"resource-manager/prometheus"
+	"strconv"
 
 	"github.com/felixge/httpsnoop"
 	"github.com/gorilla/mux"
 	log "k8s.io/klog/v2"
 )
 
-func logRequestHendler(h http.Handler) http.Handler {
+func logRequestHendler(router *mux.Router) http.Handler {
 	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
-		m := httpsnoop.CaptureMetrics(h, w, r)
-		log.Infoln(r.RemoteAddr, r.Method, r.URL, m.Code, m.Duration, m.Written)
+		m := httpsnoop.CaptureMetrics(router, w, r)
+		log.Infoln(r.RemoteAddr, r.Method, r.URL, m.Code, m.Duration, m.Written, requestID(r))
+
+		code := strconv.Itoa(m.Code)
+		route := routeTemplate(router, r)
+		metrics.HTTPRequests.WithLabelValues(route, r.Method, code).Inc()
+		metrics.HTTPRequestDuration.WithLabelValues(route, r.Method, code).Observe(m.Duration.Seconds())
 	})
 }
 
-func Run(cfg *config.Config) error {
+// routeTemplate шаблон пути обработчика запроса, например /v1/namespace/{ns}/resourcequotas
+// используется в метриках вместо пути, чтобы не плодить значения меток
+func routeTemplate(router *mux.Router, r *http.Request) string {
+	var match mux.RouteMatch
+	if !router.Match(r, &match) || match.Route == nil {
+		return "unmatched"
+	}
+
+	template, err := match.Route.GetPathTemplate()
+	if err != nil {
+		return "unmatched"
+	}
+	return template
+}
+
+// server обработчики API и их зависимости
+type server struct {
+	processing *processing.Service
+	kube       *kube.Client
+	audit      *audit.Logger
+
+	auth   authConfig
+	tokens *tokenCache
+
+	// readinessChecks зависимости, без которых сервис не может обрабатывать запросы
+	readinessChecks []*dependencyCheck
+	// shuttingDown сервис получил сигнал на завершение; /readyz в это время возвращает 503
+	shuttingDown int32
+}
+
+// newAPIServer обработчики API, работающие с kubernetes через kubeClient и с prometheus через promClient
+func newAPIServer(cfg *config.Config, kubeClient *kube.Client, promClient *prometheus.Client) (*server, error) {
+	s := &server{
+		kube: kubeClient,
+		readinessChecks: []*dependencyCheck{
+			{name: "kubernetes", check: kubeClient.Ping},
+			{name: "prometheus", check: promClient.Ping},
+		},
+	}
+
+	// настройки аутентификации и авторизации
+	err := s.initAuth(cfg.Auth)
+	if err != nil {
+		return nil, err
+	}
+
+	// расчет ресурсов и выдача квот
+	s.processing, err = processing.NewService(
+		cfg.Processing,
+		kubeClient, kubeClient, kubeClient, kubeClient, kubeClient,
+		promClient,
+	)
+	if err != nil {
+		return nil, err
+	}
+
+	// приемники аудита изменений квот и limitrange;
+	// приемник events записывает события через очередь событий processing
+	s.audit, err = audit.New(cfg.Audit, s.processing)
+	if err != nil {
+		return nil, err
+	}
+
+	return s, nil
+}
 
+// routes указание обработчиков для endpoints
+func (s *server) routes() *mux.Router {
 	router := mux.NewRouter().StrictSlash(true)
 
-	// указание обработчиков для endpoints
 	router.HandleFunc(
-		"/v1/namespace/{ns}/resourcequotas", getNameSpaceResourceQuota).Methods("GET")
+		"/v1/namespace/{ns}/resourcequotas", s.getNameSpaceResourceQuota).Methods("GET")
 
 	router.HandleFunc(
-		"/v1/business/{business}/resourcequotas", getBusinessResourceQuota).Methods("GET")
+		"/v1/business/{business}/resourcequotas", s.getBusinessResourceQuota).Methods("GET")
 
 	router.HandleFunc(
-		"/v1/business/{business}/resourceavailable", getBusinessResourceAvailable).Methods("GET")
-
-	router.HandleFunc("/v1/resourcequotas", createResourceQuota).Methods("POST")
-	router.HandleFunc("/v1/resourcequotas", updateResourceQuota).Methods("PUT")
-	// router.HandleFunc("/v1/resourcequotas", deleteResourceQuota1).Methods("DELETE")
-	// router.HandleFunc("/v1/namespace/{ns}/resourcequotas", deleteResourceQuota2).Methods("DELETE")
-	router.HandleFunc("/v1/limitranges", createLimitRange).Methods("POST")
-	router.HandleFunc("/v1/limitranges", updateLimitRange).Methods("PUT")
-	// router.HandleFunc("/v1/namespace/{ns}/deleteLimitRange", deleteLimitRange1).Methods("DELETE")
-	// router.HandleFunc("/v1/limitranges", deleteLimitRange2).Methods("DELETE")
-
-	// инициализация клиента в пакете kube для работы с kubernetes
-	err := kube.Init()
+		"/v1/business/{business}/resourceavailable", s.getBusinessResourceAvailable).Methods("GET")
+
+	router.HandleFunc(
+		"/v1/business/{business}/status", s.getBusinessStatus).Methods("GET")
+
+	router.HandleFunc("/v1/burstpool", s.getBurstPool).Methods("GET")
+
+	router.HandleFunc("/v1/audit", s.getAudit).Methods("GET")
+
+	router.Handle("/metrics", metrics.Handler()).Methods("GET")
+
+	router.HandleFunc("/healthz", healthz).Methods("GET")
+	router.HandleFunc("/readyz", s.readyz).Methods("GET")
+
+	router.HandleFunc("/v1/resourcequotas", s.createResourceQuota).Methods("POST")
+	router.HandleFunc("/v1/resourcequotas", s.updateResourceQuota).Methods("PUT")
+	router.HandleFunc("/v1/resourcequotas", s.deleteResourceQuota2).Methods("DELETE")
+	router.HandleFunc("/v1/namespace/{ns}/resourcequotas", s.deleteResourceQuota1).Methods("DELETE")
+	router.HandleFunc("/v1/limitranges", s.createLimitRange).Methods("POST")
+	router.HandleFunc("/v1/limitranges", s.updateLimitRange).Methods("PUT")
+	router.HandleFunc("/v1/namespace/{ns}/limitranges", s.deleteLimitRange1).Methods("DELETE")
+	router.HandleFunc("/v1/limitranges", s.deleteLimitRange2).Methods("DELETE")
+
+	// аутентификация запросов к API
+	router.Use(s.authHandler)
+
+	return router
+}
+
+func Run(cfg *config.Config) error {
+
+	// клиент для работы с kubernetes
+	kubeClient, err := kube.New()
+	if err != nil {
+		return err
+	}
+
+	// клиент для работы с prometheus
+	promClient, err := prometheus.New(cfg.Prometheus)
 	if err != nil {
 		return err
 	}
 
-	// инициализация клиента в пакете prometheus для работы с prometheus
-	err = prometheus.Init(cfg.Prometheus)
+	s, err := newAPIServer(cfg, kubeClient, promClient)
 	if err != nil {
 		return err
 	}
 
-	// инициализация конфигурации в пакете processing
-	err = processing.Init(cfg.Processing)
+	router := s.routes()
+
+	srv, err := newServer(cfg, requestIDHandler(logRequestHendler(router)))
 	if err != nil {
 		return err
 	}
 
-	// запуск сервера API
-	addr := cfg.Addr
-	if addr == "" {
-		addr = ":8080"
+	gracePeriod, err := parseDuration("shutdown_grace_period", cfg.ShutdownGracePeriod, DEFAULT_SHUTDOWN_GRACE_PERIOD)
+	if err != nil {
+		return err
+	}
+	delay, err := parseDuration("shutdown_delay", cfg.ShutdownDelay, DEFAULT_SHUTDOWN_DELAY)
+	if err != nil {
+		return err
 	}
-	log.Infof("Service start, listen and serve: \"%s\"", addr)
-	return http.ListenAndServe(addr, logRequestHendler(router))
+
+	// запуск сервера API и фоновых задач сервиса processing
+	return s.serve(srv, cfg.TLS, delay, gracePeriod)
 }
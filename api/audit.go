@@ -0,0 +1,158 @@
+package api
+
+import (
+	"fmt"
+	"net/http"
+	"resource-manager/audit"
+	"resource-manager/processing"
+	"strconv"
+	"strings"
+	"time"
+
+	corev1 "k8s.io/api/core/v1"
+)
+
+// newAuditEvent запись аудита операции operation с объектом resource namespace/name
+// err - ошибка, с которой завершилась операция
+func newAuditEvent(r *http.Request, operation, resource, namespace, name string, dryRun bool, err error) *audit.Event {
+	e := &audit.Event{
+		RequestID: requestID(r),
+		Operation: operation,
+		Resource:  resource,
+		Namespace: namespace,
+		Name:      name,
+		DryRun:    dryRun,
+		Verdict:   audit.VERDICT_OK,
+		Code:      http.StatusOK,
+	}
+
+	if user := requestUser(r); user != nil {
+		e.User = user.Name
+		e.Groups = user.Groups
+	}
+
+	if err != nil {
+		e.Verdict = err.Error()
+		e.Code, e.Reason = errorStatus(err)
+	}
+
+	return e
+}
+
+// auditBusiness колонна namespace для записи аудита, если она не известна из результата операции
+func (s *server) auditBusiness(e *audit.Event) {
+	if e.Business != "" || e.Namespace == "" {
+		return
+	}
+	if business, err := s.processing.NamespaceBusiness(e.Namespace); err == nil {
+		e.Business = business
+	}
+}
+
+// auditQuota запись аудита создания или изменения квоты rq
+// report - решение по запросу; nil, если расчет не выполнялся
+func (s *server) auditQuota(r *http.Request, operation string, rq *corev1.ResourceQuota, dryRun bool, report *processing.AdmissionReport, err error) {
+	// при dryRun решение возвращается в отчете без ошибки
+	if err == nil && report != nil && report.Err != nil {
+		err = report.Err
+	}
+
+	e := newAuditEvent(r, operation, "resourcequotas", rq.Namespace, rq.Name, dryRun, err)
+	e.NewHard = rq.Spec.Hard
+	if report != nil {
+		e.Business = report.Business
+		e.Name = report.Name
+		e.OldHard = report.Current
+		e.Available = report.Available
+	}
+	s.auditBusiness(e)
+
+	s.audit.Record(e)
+}
+
+// auditQuotaDelete запись аудита удаления квоты rq
+func (s *server) auditQuotaDelete(r *http.Request, rq *corev1.ResourceQuota, dryRun bool, released *processing.ReleasedResources, err error) {
+	e := newAuditEvent(r, "delete", "resourcequotas", rq.Namespace, rq.Name, dryRun, err)
+	if released != nil {
+		e.Business = released.Business
+		e.Name = released.Name
+		e.OldHard = released.Released
+		e.Available = released.Available
+	}
+	s.auditBusiness(e)
+
+	s.audit.Record(e)
+}
+
+// auditLimitRange запись аудита операции operation с LimitRange
+func (s *server) auditLimitRange(r *http.Request, operation string, lr *corev1.LimitRange, dryRun bool, err error) {
+	e := newAuditEvent(r, operation, "limitranges", lr.Namespace, lr.Name, dryRun, err)
+	s.auditBusiness(e)
+
+	s.audit.Record(e)
+}
+
+// getAudit выборка записей аудита
+// фильтры: namespace, business, user, operation, resource, rejected, since, until (RFC3339), limit
+func (s *server) getAudit(w http.ResponseWriter, r *http.Request) {
+	if err := s.authorizeAdmin(r); err != nil {
+		writeProcessingError(w, r, err)
+		return
+	}
+
+	filter, err := auditFilter(r)
+	if err != nil {
+		writeBadRequest(w, r, err.Error())
+		return
+	}
+
+	events, err := s.audit.Query(filter)
+	if err != nil {
+		writeProcessingError(w, r, err)
+		return
+	}
+
+	writeJSON(w, r, http.StatusOK, events)
+}
+
+// auditFilter фильтр записей аудита из параметров url
+func auditFilter(r *http.Request) (audit.Filter, error) {
+	q := r.URL.Query()
+	filter := audit.Filter{
+		Namespace: q.Get("namespace"),
+		Business:  strings.ToLower(q.Get("business")),
+		User:      q.Get("user"),
+		Operation: q.Get("operation"),
+		Resource:  q.Get("resource"),
+	}
+
+	if v := q.Get("rejected"); v != "" {
+		rejected, err := strconv.ParseBool(v)
+		if err != nil {
+			return filter, fmt.Errorf("rejected: %s", err)
+		}
+		filter.Rejected = &rejected
+	}
+
+	for name, t := range map[string]*time.Time{"since": &filter.Since, "until": &filter.Until} {
+		v := q.Get(name)
+		if v == "" {
+			continue
+		}
+		parsed, err := time.Parse(time.RFC3339, v)
+		if err != nil {
+			return filter, fmt.Errorf("%s: %s", name, err)
+		}
+		*t = parsed
+	}
+
+	if v := q.Get("limit"); v != "" {
+		limit, err := strconv.Atoi(v)
+		if err != nil || limit < 0 {
+			return filter, fmt.Errorf("limit: must be a non-negative integer")
+		}
+		filter.Limit = limit
+	}
+
+	return filter, nil
+}
@@ -0,0 +1,346 @@
+package api
+
+import (
+	"context"
+	"crypto/sha256"
+	"encoding/hex"
+	"errors"
+	"fmt"
+	"net/http"
+	"resource-manager/config"
+	"resource-manager/kube"
+	"strings"
+	"sync"
+	"time"
+
+	authenticationv1 "k8s.io/api/authentication/v1"
+	authorizationv1 "k8s.io/api/authorization/v1"
+	log "k8s.io/klog/v2"
+)
+
+const (
+	// AUTHORIZER_MAPPING доступ по соответствию групп пользователя колонне
+	AUTHORIZER_MAPPING = "mapping"
+	// AUTHORIZER_SUBJECT_ACCESS_REVIEW доступ по RBAC кластера через SubjectAccessReview
+	AUTHORIZER_SUBJECT_ACCESS_REVIEW = "subject_access_review"
+
+	DEFAULT_AUTH_CACHE_TTL = time.Minute
+
+	// максимальное число записей в кэше TokenReview
+	tokenCacheSize = 10000
+)
+
+var (
+	// ErrUnauthenticated не удалось определить пользователя
+	ErrUnauthenticated = errors.New("unauthenticated")
+	// ErrForbidden у пользователя нет прав на операцию
+	ErrForbidden = errors.New("forbidden")
+)
+
+// пути, доступные без аутентификации
+var publicPaths = map[string]bool{
+	"/healthz": true,
+	"/readyz":  true,
+	"/metrics": true,
+}
+
+// UserInfo пользователь, выполняющий запрос
+type UserInfo struct {
+	Name   string              `json:"name"`
+	UID    string              `json:"uid,omitempty"`
+	Groups []string            `json:"groups,omitempty"`
+	Extra  map[string][]string `json:"extra,omitempty"`
+}
+
+type authConfig struct {
+	Enabled        bool
+	Audiences      []string
+	CacheTTL       time.Duration
+	Authorizer     string
+	AdminGroups    []string
+	BusinessGroups map[string][]string
+	Impersonate    bool
+}
+
+// tokenCacheEntry результат TokenReview
+type tokenCacheEntry struct {
+	user    *UserInfo
+	expires time.Time
+}
+
+// tokenCache кэш успешных TokenReview по хэшу токена
+type tokenCache struct {
+	mu      sync.Mutex
+	entries map[string]tokenCacheEntry
+	// ttl время хранения записи, auth.cache_ttl
+	ttl time.Duration
+}
+
+// initAuth инициализация настроек аутентификации и авторизации
+func (s *server) initAuth(c config.AuthType) error {
+	s.auth = authConfig{
+		Enabled:        c.Enabled,
+		Audiences:      c.Audiences,
+		AdminGroups:    c.AdminGroups,
+		BusinessGroups: make(map[string][]string),
+		Impersonate:    c.Impersonate,
+	}
+
+	var err error
+	if s.auth.CacheTTL, err = parseDuration("auth.cache_ttl", c.CacheTTL, DEFAULT_AUTH_CACHE_TTL); err != nil {
+		return err
+	}
+
+	s.auth.Authorizer = c.Authorizer
+	if s.auth.Authorizer == "" {
+		s.auth.Authorizer = AUTHORIZER_MAPPING
+	}
+	if s.auth.Authorizer != AUTHORIZER_MAPPING && s.auth.Authorizer != AUTHORIZER_SUBJECT_ACCESS_REVIEW {
+		return fmt.Errorf("auth: unknown authorizer %q", s.auth.Authorizer)
+	}
+
+	if s.auth.Impersonate && !s.auth.Enabled {
+		return fmt.Errorf("auth: impersonate requires auth to be enabled")
+	}
+
+	for business, groups := range c.BusinessGroups {
+		s.auth.BusinessGroups[strings.ToLower(business)] = groups
+	}
+
+	s.tokens = &tokenCache{entries: make(map[string]tokenCacheEntry), ttl: s.auth.CacheTTL}
+	return nil
+}
+
+// authHandler аутентификация запросов
+// пользователь сохраняется в контексте запроса; пути из publicPaths не проверяются
+func (s *server) authHandler(h http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if !s.auth.Enabled || publicPaths[r.URL.Path] {
+			h.ServeHTTP(w, r)
+			return
+		}
+
+		user, err := s.authenticate(r)
+		if err != nil {
+			log.Warningf("Authenticate %s %s %s: %s", r.RemoteAddr, r.Method, r.URL, err)
+			w.Header().Set("WWW-Authenticate", `Bearer realm="resource-manager"`)
+			writeProcessingError(w, r, err)
+			return
+		}
+
+		h.ServeHTTP(w, r.WithContext(context.WithValue(r.Context(), userKey, user)))
+	})
+}
+
+// requestUser пользователь текущего запроса; nil, если аутентификация выключена
+func requestUser(r *http.Request) *UserInfo {
+	user, _ := r.Context().Value(userKey).(*UserInfo)
+	return user
+}
+
+// impersonateUser пользователь запроса для записи объектов от его имени
+// nil, если impersonation выключен или пользователь не определен
+func (s *server) impersonateUser(r *http.Request) *kube.User {
+	if !s.auth.Impersonate {
+		return nil
+	}
+
+	user := requestUser(r)
+	if user == nil {
+		return nil
+	}
+	return &kube.User{Name: user.Name, Groups: user.Groups, Extra: user.Extra}
+}
+
+// authenticate определение пользователя по клиентскому сертификату или bearer-токену
+func (s *server) authenticate(r *http.Request) (*UserInfo, error) {
+	// сертификат проверен сервером по client_ca_file:
+	// имя пользователя - CN, группы - O
+	if r.TLS != nil && len(r.TLS.VerifiedChains) > 0 && len(r.TLS.VerifiedChains[0]) > 0 {
+		subject := r.TLS.VerifiedChains[0][0].Subject
+		return &UserInfo{Name: subject.CommonName, Groups: subject.Organization}, nil
+	}
+
+	header := r.Header.Get("Authorization")
+	if !strings.HasPrefix(header, "Bearer ") {
+		return nil, fmt.Errorf("%w: no client certificate or bearer token", ErrUnauthenticated)
+	}
+	token := strings.TrimSpace(strings.TrimPrefix(header, "Bearer "))
+	if token == "" {
+		return nil, fmt.Errorf("%w: empty bearer token", ErrUnauthenticated)
+	}
+
+	return s.reviewToken(token)
+}
+
+// reviewToken проверка токена через TokenReview с кэшированием успешных результатов
+func (s *server) reviewToken(token string) (*UserInfo, error) {
+	sum := sha256.Sum256([]byte(token))
+	key := hex.EncodeToString(sum[:])
+
+	if user, ok := s.tokens.get(key); ok {
+		return user, nil
+	}
+
+	review, err := s.kube.CreateTokenReview(&authenticationv1.TokenReview{
+		Spec: authenticationv1.TokenReviewSpec{Token: token, Audiences: s.auth.Audiences},
+	})
+	if err != nil {
+		return nil, err
+	}
+
+	if !review.Status.Authenticated {
+		if review.Status.Error != "" {
+			return nil, fmt.Errorf("%w: %s", ErrUnauthenticated, review.Status.Error)
+		}
+		return nil, fmt.Errorf("%w: token is not valid", ErrUnauthenticated)
+	}
+
+	user := &UserInfo{
+		Name:   review.Status.User.Username,
+		UID:    review.Status.User.UID,
+		Groups: review.Status.User.Groups,
+		Extra:  make(map[string][]string),
+	}
+	for k, v := range review.Status.User.Extra {
+		user.Extra[k] = v
+	}
+
+	s.tokens.add(key, user)
+	return user, nil
+}
+
+// get пользователь по хэшу токена, если запись не устарела
+func (c *tokenCache) get(key string) (*UserInfo, bool) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	entry, ok := c.entries[key]
+	if !ok {
+		return nil, false
+	}
+	if time.Now().After(entry.expires) {
+		delete(c.entries, key)
+		return nil, false
+	}
+	return entry.user, true
+}
+
+// add сохранение пользователя по хэшу токена на время auth.cache_ttl
+func (c *tokenCache) add(key string, user *UserInfo) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	// при переполнении кэш очищается целиком
+	if len(c.entries) >= tokenCacheSize {
+		c.entries = make(map[string]tokenCacheEntry)
+	}
+	c.entries[key] = tokenCacheEntry{user: user, expires: time.Now().Add(c.ttl)}
+}
+
+// authorize проверка прав пользователя запроса на операцию verb с ресурсом resource в namespace
+func (s *server) authorize(r *http.Request, namespace, verb, resource string) error {
+	if !s.auth.Enabled {
+		return nil
+	}
+
+	user := requestUser(r)
+	if user == nil {
+		return ErrUnauthenticated
+	}
+
+	if intersects(user.Groups, s.auth.AdminGroups) {
+		return nil
+	}
+
+	if s.auth.Authorizer == AUTHORIZER_SUBJECT_ACCESS_REVIEW {
+		return s.subjectAccessReview(user, namespace, verb, resource)
+	}
+	return s.authorizeBusiness(user, namespace)
+}
+
+// authorizeAdmin доступ только для пользователей из auth.admin_groups
+func (s *server) authorizeAdmin(r *http.Request) error {
+	if !s.auth.Enabled {
+		return nil
+	}
+
+	user := requestUser(r)
+	if user == nil {
+		return ErrUnauthenticated
+	}
+
+	if !intersects(user.Groups, s.auth.AdminGroups) {
+		return fmt.Errorf("%w: user %s is not a member of the admin groups", ErrForbidden, user.Name)
+	}
+	return nil
+}
+
+// authorizeBusiness доступ, если пользователь входит в группу колонны namespace
+// группы колонны задаются в auth.business_groups, по умолчанию - группа с именем колонны
+func (s *server) authorizeBusiness(user *UserInfo, namespace string) error {
+	business, err := s.processing.NamespaceBusiness(namespace)
+	if err != nil {
+		return err
+	}
+
+	groups, ok := s.auth.BusinessGroups[business]
+	if !ok {
+		groups = []string{business}
+	}
+
+	if !intersects(user.Groups, groups) {
+		return fmt.Errorf("%w: user %s is not a member of the business %s", ErrForbidden, user.Name, business)
+	}
+	return nil
+}
+
+// subjectAccessReview доступ по RBAC кластера
+func (s *server) subjectAccessReview(user *UserInfo, namespace, verb, resource string) error {
+	extra := make(map[string]authorizationv1.ExtraValue)
+	for k, v := range user.Extra {
+		extra[k] = v
+	}
+
+	review, err := s.kube.CreateSubjectAccessReview(&authorizationv1.SubjectAccessReview{
+		Spec: authorizationv1.SubjectAccessReviewSpec{
+			ResourceAttributes: &authorizationv1.ResourceAttributes{
+				Namespace: namespace,
+				Verb:      verb,
+				Resource:  resource,
+			},
+			User:   user.Name,
+			UID:    user.UID,
+			Groups: user.Groups,
+			Extra:  extra,
+		},
+	})
+	if err != nil {
+		return err
+	}
+
+	if !review.Status.Allowed {
+		return fmt.Errorf("%w: user %s cannot %s %s in the namespace %s", ErrForbidden, user.Name, verb, resource, namespace)
+	}
+	return nil
+}
+
+// intersects есть ли общие элементы в списках
+func intersects(a, b []string) bool {
+	for _, s := range a {
+		if stringInSlice(s, b) {
+			return true
+		}
+	}
+	return false
+}
+
+// stringInSlice проверка на наличие строки в списке
+func stringInSlice(a string, list []string) bool {
+	for _, b := range list {
+		if b == a {
+			return true
+		}
+	}
+	return false
+}
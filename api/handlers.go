@@ -2,275 +2,403 @@ package api
 
 import (
 	"encoding/json"
+	"fmt"
 	"net/http"
 	"resource-manager/processing"
+	"time"
 
 	"github.com/gorilla/mux"
 	corev1 "k8s.io/api/core/v1"
+	apierrors "k8s.io/apimachinery/pkg/api/errors"
 )
 
 // getNameSpaceResourceQuota получение назначенной ResourceQuota в namespace
-func getNameSpaceResourceQuota(w http.ResponseWriter, r *http.Request) {
+func (s *server) getNameSpaceResourceQuota(w http.ResponseWriter, r *http.Request) {
 	vars := mux.Vars(r)
 	ns, ok := vars["ns"]
 	if !ok {
-		http.Error(w, "namespace not specified", http.StatusBadRequest)
+		writeBadRequest(w, r, "namespace not specified")
 		return
 	}
 
-	rq, err := processing.GetResourceQuota(ns)
+	rq, err := s.processing.GetResourceQuota(ns)
 	if err != nil {
-		http.Error(w, err.Error(), http.StatusInternalServerError)
+		writeProcessingError(w, r, err)
 		return
 	}
 
-	jsonData, err := json.Marshal(rq)
-	if err != nil {
-		http.Error(w, err.Error(), http.StatusInternalServerError)
-		return
-	}
-
-	w.Header().Set("Content-Type", "application/json")
-	w.Write(jsonData)
+	writeJSON(w, r, http.StatusOK, rq)
 }
 
 // getBusinessResourceQuota получение назначенной ResourceQuota у колонны
-func getBusinessResourceQuota(w http.ResponseWriter, r *http.Request) {
+func (s *server) getBusinessResourceQuota(w http.ResponseWriter, r *http.Request) {
 	vars := mux.Vars(r)
 	business, ok := vars["business"]
 	if !ok {
-		http.Error(w, "business name not specified", http.StatusBadRequest)
-		return
-	}
-
-	resourcesHard, err := processing.GetResourcesHard(business)
-	if err != nil {
-		http.Error(w, err.Error(), http.StatusInternalServerError)
+		writeBadRequest(w, r, "business name not specified")
 		return
 	}
 
-	jsonData, err := json.Marshal(resourcesHard)
+	resourcesHard, err := s.processing.GetResourcesHard(business)
 	if err != nil {
-		http.Error(w, err.Error(), http.StatusInternalServerError)
+		writeProcessingError(w, r, err)
 		return
 	}
 
-	w.Header().Set("Content-Type", "application/json")
-	w.Write(jsonData)
+	writeJSON(w, r, http.StatusOK, resourcesHard)
 }
 
 // getBusinessResourceAvailable получение доступных ресурсов у колонны
-func getBusinessResourceAvailable(w http.ResponseWriter, r *http.Request) {
+func (s *server) getBusinessResourceAvailable(w http.ResponseWriter, r *http.Request) {
 	vars := mux.Vars(r)
 	business, ok := vars["business"]
 	if !ok {
-		http.Error(w, "business name not specified", http.StatusBadRequest)
+		writeBadRequest(w, r, "business name not specified")
 		return
 	}
 
-	resourceAvailable, err := processing.ResourceAvailable(business)
+	resourceAvailable, err := s.processing.ResourceAvailable(business)
 	if err != nil {
-		http.Error(w, err.Error(), http.StatusInternalServerError)
+		writeProcessingError(w, r, err)
+		return
+	}
+
+	writeJSON(w, r, http.StatusOK, resourceAvailable)
+}
+
+// getBusinessStatus получение результата проверки колонны на превышение квот над рассчитанными ресурсами
+func (s *server) getBusinessStatus(w http.ResponseWriter, r *http.Request) {
+	vars := mux.Vars(r)
+	business, ok := vars["business"]
+	if !ok {
+		writeBadRequest(w, r, "business name not specified")
 		return
 	}
 
-	jsonData, err := json.Marshal(resourceAvailable)
+	status, err := s.processing.GetBusinessStatus(business)
 	if err != nil {
-		http.Error(w, err.Error(), http.StatusInternalServerError)
+		writeProcessingError(w, r, err)
 		return
 	}
 
-	w.Header().Set("Content-Type", "application/json")
-	w.Write(jsonData)
+	writeJSON(w, r, http.StatusOK, status)
 }
 
 // createResourceQuota функция-обработчик по созданию ResourceQuota
-func createResourceQuota(w http.ResponseWriter, r *http.Request) {
+func (s *server) createResourceQuota(w http.ResponseWriter, r *http.Request) {
 	body := new(BodyResourceQuota)
 	err := json.NewDecoder(r.Body).Decode(body)
 	if err != nil {
-		http.Error(w, err.Error(), http.StatusBadRequest)
+		writeBadRequest(w, r, err.Error())
 		return
 	}
 
 	// формирование объекта ResourceQuota с данными из запроса
 	newRQ := &corev1.ResourceQuota{ObjectMeta: body.MetaData, Spec: body.Spec}
 
+	opts, err := s.admissionOptions(r, body)
+	if err != nil {
+		writeBadRequest(w, r, err.Error())
+		return
+	}
+
+	if err := s.authorize(r, newRQ.Namespace, "create", "resourcequotas"); err != nil {
+		s.auditQuota(r, "create", newRQ, opts.DryRun, nil, err)
+		writeProcessingError(w, r, err)
+		return
+	}
+
 	// создание DefaultLimitRanges в namespace
 	// для задания реквес/лимитов у контейнеров по умолчанию
-	if limitrange := r.URL.Query().Get("limitrange"); limitrange != "false" {
-		processing.CreateDefaultLimitRanges(body.MetaData.Namespace)
+	if limitrange := r.URL.Query().Get("limitrange"); limitrange != "false" && !opts.DryRun {
+		lr, err := s.processing.CreateDefaultLimitRanges(body.MetaData.Namespace, opts.Impersonate)
+		// существующий LimitRange не изменяется
+		if !apierrors.IsAlreadyExists(err) {
+			s.auditLimitRange(r, "create", lr, false, err)
+		}
 	}
 
-	if err := processing.CreateResourceQuota(newRQ); err != nil {
-		if err == processing.ErrNoResourcesAvailable {
-			http.Error(w, err.Error(), http.StatusPreconditionFailed)
-			return
-		}
-		http.Error(w, err.Error(), http.StatusInternalServerError)
+	created, report, err := s.processing.CreateResourceQuota(newRQ, opts)
+	s.auditQuota(r, "create", newRQ, opts.DryRun, report, err)
+	if err != nil {
+		writeProcessingError(w, r, err)
+		return
+	}
+
+	if opts.DryRun {
+		writeDryRunReport(w, r, report)
 		return
 	}
 
-	w.Write([]byte("ok\n"))
+	writeJSON(w, r, http.StatusOK, created)
 }
 
 // updateResourceQuota функция-обработчик по изменению ResourceQuota
-func updateResourceQuota(w http.ResponseWriter, r *http.Request) {
+func (s *server) updateResourceQuota(w http.ResponseWriter, r *http.Request) {
 	body := new(BodyResourceQuota)
 	err := json.NewDecoder(r.Body).Decode(body)
 	if err != nil {
-		http.Error(w, err.Error(), http.StatusBadRequest)
+		writeBadRequest(w, r, err.Error())
 		return
 	}
 
 	// формирование объекта ResourceQuota с данными из запроса
 	newRQ := &corev1.ResourceQuota{ObjectMeta: body.MetaData, Spec: body.Spec}
 
-	if err := processing.UpdateResourceQuota(newRQ); err != nil {
-		if err == processing.ErrNoResourcesAvailable {
-			http.Error(w, err.Error(), http.StatusPreconditionFailed)
-			return
+	opts, err := s.admissionOptions(r, body)
+	if err != nil {
+		writeBadRequest(w, r, err.Error())
+		return
+	}
+
+	if err := s.authorize(r, newRQ.Namespace, "update", "resourcequotas"); err != nil {
+		s.auditQuota(r, "update", newRQ, opts.DryRun, nil, err)
+		writeProcessingError(w, r, err)
+		return
+	}
+
+	updated, report, err := s.processing.UpdateResourceQuota(newRQ, opts)
+	s.auditQuota(r, "update", newRQ, opts.DryRun, report, err)
+	if err != nil {
+		writeProcessingError(w, r, err)
+		return
+	}
+
+	if opts.DryRun {
+		writeDryRunReport(w, r, report)
+		return
+	}
+
+	writeJSON(w, r, http.StatusOK, updated)
+}
+
+// admissionOptions параметры запроса квоты из url:
+// dryRun=true - только расчет, burst=<срок> - заем недостающих ресурсов из burst-пула;
+// и из тела запроса: ttl или expiresAt - срок выдачи квоты
+func (s *server) admissionOptions(r *http.Request, body *BodyResourceQuota) (processing.AdmissionOptions, error) {
+	opts := processing.AdmissionOptions{
+		DryRun:      r.URL.Query().Get("dryRun") == "true",
+		Impersonate: s.impersonateUser(r),
+	}
+
+	switch {
+	case body.TTL != "" && body.ExpiresAt != nil:
+		return opts, fmt.Errorf("only one of ttl and expiresAt can be specified")
+	case body.TTL != "":
+		ttl, err := time.ParseDuration(body.TTL)
+		if err != nil {
+			return opts, fmt.Errorf("ttl: %s", err)
 		}
-		if err == processing.ErrRequestedQuotaIsLessUsed {
-			http.Error(w, err.Error(), http.StatusConflict)
-			return
+		if ttl <= 0 {
+			return opts, fmt.Errorf("ttl: duration must be positive")
 		}
-		http.Error(w, err.Error(), http.StatusInternalServerError)
+		opts.ExpiresAt = time.Now().Add(ttl).Truncate(time.Second)
+	case body.ExpiresAt != nil:
+		opts.ExpiresAt = *body.ExpiresAt
+	}
+
+	if burst := r.URL.Query().Get("burst"); burst != "" {
+		d, err := time.ParseDuration(burst)
+		if err != nil {
+			return opts, fmt.Errorf("burst: %s", err)
+		}
+		if d <= 0 {
+			return opts, fmt.Errorf("burst: duration must be positive")
+		}
+		opts.Burst = d
+	}
+
+	return opts, nil
+}
+
+// getBurstPool получение размера и занятости burst-пула
+func (s *server) getBurstPool(w http.ResponseWriter, r *http.Request) {
+	status, err := s.processing.GetBurstPoolStatus()
+	if err != nil {
+		writeProcessingError(w, r, err)
 		return
 	}
 
-	w.Write([]byte("ok\n"))
+	writeJSON(w, r, http.StatusOK, status)
+}
+
+// writeDryRunReport ответ на запрос с dryRun: данные расчета и http-код,
+// с которым был бы выполнен запрос
+func writeDryRunReport(w http.ResponseWriter, r *http.Request, report *processing.AdmissionReport) {
+	code, reason := http.StatusOK, ""
+	if report.Err != nil {
+		code, reason = errorStatus(report.Err)
+	}
 
+	writeJSON(w, r, http.StatusOK, DryRunReport{AdmissionReport: report, Code: code, Reason: reason})
 }
 
 // deleteResourceQuota1 удаление ResourceQuota в namespace; данные берутся из url
-func deleteResourceQuota1(w http.ResponseWriter, r *http.Request) {
+func (s *server) deleteResourceQuota1(w http.ResponseWriter, r *http.Request) {
 	vars := mux.Vars(r)
 	ns, ok := vars["ns"]
 	if !ok {
-		http.Error(w, "namespace not specified", http.StatusBadRequest)
+		writeBadRequest(w, r, "namespace not specified")
 		return
 	}
 
 	MetaData := new(BodyResourceQuota).MetaData
-
 	MetaData.Namespace = ns
+	MetaData.Name = r.URL.Query().Get("name")
 
-	rq := &corev1.ResourceQuota{ObjectMeta: MetaData}
-
-	err := processing.DeleteResourceQuota(rq)
-	if err != nil {
-		http.Error(w, err.Error(), http.StatusInternalServerError)
-		return
-	}
+	s.deleteResourceQuota(w, r, &corev1.ResourceQuota{ObjectMeta: MetaData})
 }
 
 // deleteResourceQuota2 удаление ResourceQuota в namespace; данные берутся из body
-func deleteResourceQuota2(w http.ResponseWriter, r *http.Request) {
+func (s *server) deleteResourceQuota2(w http.ResponseWriter, r *http.Request) {
 	body := new(BodyResourceQuota)
 	err := json.NewDecoder(r.Body).Decode(body)
 	if err != nil {
-		http.Error(w, err.Error(), http.StatusBadRequest)
+		writeBadRequest(w, r, err.Error())
 		return
 	}
 
 	MetaData := new(BodyResourceQuota).MetaData
-
 	MetaData.Namespace = body.MetaData.Namespace
+	MetaData.Name = body.MetaData.Name
+
+	s.deleteResourceQuota(w, r, &corev1.ResourceQuota{ObjectMeta: MetaData})
+}
+
+// deleteResourceQuota удаление ResourceQuota и ответ с освобожденными у колонны ресурсами
+func (s *server) deleteResourceQuota(w http.ResponseWriter, r *http.Request, rq *corev1.ResourceQuota) {
+	if rq.Namespace == "" {
+		writeBadRequest(w, r, "namespace not specified")
+		return
+	}
 
-	rq := &corev1.ResourceQuota{ObjectMeta: MetaData}
+	dryRun := r.URL.Query().Get("dryRun") == "true"
 
-	err = processing.DeleteResourceQuota(rq)
+	if err := s.authorize(r, rq.Namespace, "delete", "resourcequotas"); err != nil {
+		s.auditQuotaDelete(r, rq, dryRun, nil, err)
+		writeProcessingError(w, r, err)
+		return
+	}
+
+	released, err := s.processing.DeleteResourceQuota(rq, dryRun, s.impersonateUser(r))
+	s.auditQuotaDelete(r, rq, dryRun, released, err)
 	if err != nil {
-		http.Error(w, err.Error(), http.StatusInternalServerError)
+		writeProcessingError(w, r, err)
 		return
 	}
+
+	writeJSON(w, r, http.StatusOK, released)
 }
 
 // createLimitRange функция-обработчик по созданию LimitRange
-func createLimitRange(w http.ResponseWriter, r *http.Request) {
+func (s *server) createLimitRange(w http.ResponseWriter, r *http.Request) {
 	body := new(BodyLimitRange)
 	err := json.NewDecoder(r.Body).Decode(body)
 	if err != nil {
-		http.Error(w, err.Error(), http.StatusBadRequest)
+		writeBadRequest(w, r, err.Error())
 		return
 	}
 
 	// формирование объекта LimitRange с данными из запроса
 	limitRange := &corev1.LimitRange{ObjectMeta: body.MetaData, Spec: body.Spec}
 
-	err = processing.CreateLimitRanges(limitRange)
-	if err != nil {
-		http.Error(w, err.Error(), http.StatusInternalServerError)
+	if err := s.authorize(r, limitRange.Namespace, "create", "limitranges"); err != nil {
+		s.auditLimitRange(r, "create", limitRange, false, err)
+		writeProcessingError(w, r, err)
 		return
 	}
 
-	w.Write([]byte("ok\n"))
+	created, err := s.processing.CreateLimitRanges(limitRange, s.impersonateUser(r))
+	s.auditLimitRange(r, "create", limitRange, false, err)
+	if err != nil {
+		writeProcessingError(w, r, err)
+		return
+	}
 
+	writeJSON(w, r, http.StatusOK, created)
 }
 
 // updateLimitRange функция-обработчик по изменению LimitRange
-func updateLimitRange(w http.ResponseWriter, r *http.Request) {
+func (s *server) updateLimitRange(w http.ResponseWriter, r *http.Request) {
 	body := new(BodyLimitRange)
 	err := json.NewDecoder(r.Body).Decode(body)
 	if err != nil {
-		http.Error(w, err.Error(), http.StatusBadRequest)
+		writeBadRequest(w, r, err.Error())
 		return
 	}
 
 	// формирование объекта LimitRange с данными из запроса
 	limitRange := &corev1.LimitRange{ObjectMeta: body.MetaData, Spec: body.Spec}
 
-	err = processing.UpdateLimitRanges(limitRange)
-	if err != nil {
-		http.Error(w, err.Error(), http.StatusInternalServerError)
+	if err := s.authorize(r, limitRange.Namespace, "update", "limitranges"); err != nil {
+		s.auditLimitRange(r, "update", limitRange, false, err)
+		writeProcessingError(w, r, err)
 		return
 	}
 
-	w.Write([]byte("ok\n"))
+	updated, err := s.processing.UpdateLimitRanges(limitRange, s.impersonateUser(r))
+	s.auditLimitRange(r, "update", limitRange, false, err)
+	if err != nil {
+		writeProcessingError(w, r, err)
+		return
+	}
 
+	writeJSON(w, r, http.StatusOK, updated)
 }
 
 // deleteLimitRange1 удаление LimitRange в namespace; данные берутся из url
-func deleteLimitRange1(w http.ResponseWriter, r *http.Request) {
+func (s *server) deleteLimitRange1(w http.ResponseWriter, r *http.Request) {
 	vars := mux.Vars(r)
 	ns, ok := vars["ns"]
 	if !ok {
-		http.Error(w, "namespace not specified", http.StatusBadRequest)
+		writeBadRequest(w, r, "namespace not specified")
 		return
 	}
 
 	MetaData := new(BodyLimitRange).MetaData
 	MetaData.Namespace = ns
+	MetaData.Name = r.URL.Query().Get("name")
 
-	limitRange := &corev1.LimitRange{ObjectMeta: MetaData}
-
-	err := processing.DeleteLimitRanges(limitRange)
-	if err != nil {
-		http.Error(w, err.Error(), http.StatusInternalServerError)
-		return
-	}
-
+	s.deleteLimitRange(w, r, &corev1.LimitRange{ObjectMeta: MetaData})
 }
 
 // deleteLimitRange2 удаление LimitRange в namespace; данные берутся из body
-func deleteLimitRange2(w http.ResponseWriter, r *http.Request) {
+func (s *server) deleteLimitRange2(w http.ResponseWriter, r *http.Request) {
 	body := new(BodyLimitRange)
 	err := json.NewDecoder(r.Body).Decode(body)
 	if err != nil {
-		http.Error(w, err.Error(), http.StatusBadRequest)
+		writeBadRequest(w, r, err.Error())
 		return
 	}
 
 	MetaData := new(BodyLimitRange).MetaData
 	MetaData.Namespace = body.MetaData.Namespace
+	MetaData.Name = body.MetaData.Name
 
-	limitRange := &corev1.LimitRange{ObjectMeta: MetaData}
+	s.deleteLimitRange(w, r, &corev1.LimitRange{ObjectMeta: MetaData})
+}
+
+// deleteLimitRange удаление LimitRange и ответ с удаленным объектом
+func (s *server) deleteLimitRange(w http.ResponseWriter, r *http.Request, limitRange *corev1.LimitRange) {
+	if limitRange.Namespace == "" {
+		writeBadRequest(w, r, "namespace not specified")
+		return
+	}
+
+	dryRun := r.URL.Query().Get("dryRun") == "true"
+
+	if err := s.authorize(r, limitRange.Namespace, "delete", "limitranges"); err != nil {
+		s.auditLimitRange(r, "delete", limitRange, dryRun, err)
+		writeProcessingError(w, r, err)
+		return
+	}
 
-	err = processing.DeleteLimitRanges(limitRange)
+	deleted, err := s.processing.DeleteLimitRanges(limitRange, dryRun, s.impersonateUser(r))
+	s.auditLimitRange(r, "delete", limitRange, dryRun, err)
 	if err != nil {
-		http.Error(w, err.Error(), http.StatusInternalServerError)
+		writeProcessingError(w, r, err)
 		return
 	}
 
+	writeJSON(w, r, http.StatusOK, deleted)
 }
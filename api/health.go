@@ -0,0 +1,107 @@
+package api
+
+import (
+	"context"
+	"net/http"
+	"sync"
+	"sync/atomic"
+	"time"
+)
+
+const (
+	HEALTH_STATUS_OK   = "ok"
+	HEALTH_STATUS_FAIL = "fail"
+
+	// время, в течение которого используется результат проверки зависимости
+	healthCacheTTL = 10 * time.Second
+	// ограничение времени проверки зависимости; меньше timeoutSeconds readinessProbe (5s),
+	// чтобы /readyz успевал ответить 503, а не завершался по таймауту пробы
+	healthCheckTimeout = 3 * time.Second
+)
+
+// CheckResult результат проверки зависимости
+type CheckResult struct {
+	Status    string    `json:"status"`
+	Error     string    `json:"error,omitempty"`
+	CheckedAt time.Time `json:"checkedAt"`
+}
+
+// HealthResponse ответ /healthz и /readyz
+type HealthResponse struct {
+	Status string                 `json:"status"`
+	Checks map[string]CheckResult `json:"checks,omitempty"`
+}
+
+// dependencyCheck проверка зависимости с кэшированием результата
+type dependencyCheck struct {
+	name  string
+	check func(ctx context.Context) error
+
+	mu     sync.Mutex
+	result CheckResult
+}
+
+// run результат проверки; проверка выполняется заново, если результат старше healthCacheTTL
+// проверка выполняется без блокировки c.mu и ограничена healthCheckTimeout,
+// поэтому зависшая зависимость не блокирует следующие запросы /readyz
+func (c *dependencyCheck) run() CheckResult {
+	c.mu.Lock()
+	cached := c.result
+	c.mu.Unlock()
+	if time.Since(cached.CheckedAt) < healthCacheTTL {
+		return cached
+	}
+
+	ctx, cancel := context.WithTimeout(context.Background(), healthCheckTimeout)
+	defer cancel()
+
+	result := CheckResult{Status: HEALTH_STATUS_OK, CheckedAt: time.Now()}
+	if err := c.check(ctx); err != nil {
+		result.Status = HEALTH_STATUS_FAIL
+		result.Error = err.Error()
+	}
+
+	c.mu.Lock()
+	c.result = result
+	c.mu.Unlock()
+	return result
+}
+
+// healthz проверка, что сервис запущен и обрабатывает запросы
+func healthz(w http.ResponseWriter, r *http.Request) {
+	writeJSON(w, r, http.StatusOK, HealthResponse{Status: HEALTH_STATUS_OK})
+}
+
+// readyz проверка доступности kubernetes API и prometheus
+// при недоступности любой из зависимостей или при завершении сервиса возвращается 503
+func (s *server) readyz(w http.ResponseWriter, r *http.Request) {
+	// при завершении сервиса новые запросы на него не направляются
+	if atomic.LoadInt32(&s.shuttingDown) == 1 {
+		writeJSON(w, r, http.StatusServiceUnavailable, HealthResponse{Status: HEALTH_STATUS_FAIL})
+		return
+	}
+
+	results := make([]CheckResult, len(s.readinessChecks))
+
+	var wg sync.WaitGroup
+	for i, c := range s.readinessChecks {
+		wg.Add(1)
+		go func(i int, c *dependencyCheck) {
+			defer wg.Done()
+			results[i] = c.run()
+		}(i, c)
+	}
+	wg.Wait()
+
+	response := HealthResponse{Status: HEALTH_STATUS_OK, Checks: make(map[string]CheckResult)}
+	code := http.StatusOK
+	for i, c := range s.readinessChecks {
+		response.Checks[c.name] = results[i]
+		if results[i].Status != HEALTH_STATUS_OK {
+			response.Status = HEALTH_STATUS_FAIL
+			code = http.StatusServiceUnavailable
+		}
+	}
+
+	writeJSON(w, r, code, response)
+}
@@ -0,0 +1,150 @@
+package api
+
+import (
+	"context"
+	"crypto/rand"
+	"encoding/hex"
+	"encoding/json"
+	"errors"
+	"net/http"
+	"resource-manager/processing"
+
+	apierrors "k8s.io/apimachinery/pkg/api/errors"
+	log "k8s.io/klog/v2"
+)
+
+const (
+	// заголовок с идентификатором запроса
+	requestIDHeader = "X-Request-Id"
+
+	REASON_BAD_REQUEST    = "BadRequest"
+	REASON_UNAUTHORIZED   = "Unauthorized"
+	REASON_NOT_FOUND      = "NotFound"
+	REASON_FORBIDDEN      = "Forbidden"
+	REASON_ALREADY_EXISTS = "AlreadyExists"
+	REASON_INTERNAL_ERROR = "InternalError"
+)
+
+type contextKey int
+
+const (
+	requestIDKey contextKey = iota
+	userKey
+)
+
+// requestIDHandler присвоение идентификатора запросу
+// идентификатор берется из заголовка X-Request-Id или генерируется
+func requestIDHandler(h http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		id := r.Header.Get(requestIDHeader)
+		if id == "" {
+			id = newRequestID()
+		}
+		w.Header().Set(requestIDHeader, id)
+		h.ServeHTTP(w, r.WithContext(context.WithValue(r.Context(), requestIDKey, id)))
+	})
+}
+
+// newRequestID генерация идентификатора запроса
+func newRequestID() string {
+	b := make([]byte, 16)
+	if _, err := rand.Read(b); err != nil {
+		log.Errorf("Generate request id: %s", err)
+		return ""
+	}
+	return hex.EncodeToString(b)
+}
+
+// requestID идентификатор текущего запроса
+func requestID(r *http.Request) string {
+	id, _ := r.Context().Value(requestIDKey).(string)
+	return id
+}
+
+// writeJSON ответ с объектом v в формате json
+func writeJSON(w http.ResponseWriter, r *http.Request, code int, v interface{}) {
+	jsonData, err := json.Marshal(v)
+	if err != nil {
+		writeError(w, r, http.StatusInternalServerError, REASON_INTERNAL_ERROR, err.Error(), nil)
+		return
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(code)
+	w.Write(jsonData)
+}
+
+// writeError ответ с ошибкой в формате ErrorResponse
+func writeError(w http.ResponseWriter, r *http.Request, code int, reason, message string, details []processing.ResourceShortfall) {
+	writeErrorResponse(w, ErrorResponse{
+		Code:      code,
+		Reason:    reason,
+		Message:   message,
+		Details:   details,
+		RequestID: requestID(r),
+	})
+}
+
+// writeErrorResponse ответ с ошибкой resp
+func writeErrorResponse(w http.ResponseWriter, resp ErrorResponse) {
+	jsonData, err := json.Marshal(resp)
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusInternalServerError)
+		return
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(resp.Code)
+	w.Write(jsonData)
+}
+
+// writeBadRequest ответ с ошибкой в данных запроса
+func writeBadRequest(w http.ResponseWriter, r *http.Request, message string) {
+	writeError(w, r, http.StatusBadRequest, REASON_BAD_REQUEST, message, nil)
+}
+
+// writeProcessingError ответ с ошибкой обработки запроса
+// код, причина и нехватка ресурсов определяются по ошибке
+func writeProcessingError(w http.ResponseWriter, r *http.Request, err error) {
+	code, reason := errorStatus(err)
+	writeErrorResponse(w, ErrorResponse{
+		Code:      code,
+		Reason:    reason,
+		Message:   err.Error(),
+		Details:   processing.Shortfall(err),
+		Resources: processing.NotAllowedResources(err),
+		RequestID: requestID(r),
+	})
+}
+
+// errorStatus http-код ответа и причина по ошибке обработки запроса
+func errorStatus(err error) (int, string) {
+	switch {
+	case errors.Is(err, processing.ErrNoResourcesAvailable):
+		return http.StatusPreconditionFailed, processing.Reason(err)
+	case errors.Is(err, processing.ErrRequestedQuotaIsLessUsed):
+		return http.StatusConflict, processing.Reason(err)
+	case errors.Is(err, processing.ErrAdmissionLockTimeout):
+		return http.StatusServiceUnavailable, processing.Reason(err)
+	case errors.Is(err, processing.ErrResourcesNotAllowed):
+		return http.StatusBadRequest, processing.Reason(err)
+	case errors.Is(err, processing.ErrBurstNotAllowed):
+		return http.StatusBadRequest, processing.Reason(err)
+	case errors.Is(err, processing.ErrExpiryNotAllowed):
+		return http.StatusBadRequest, processing.Reason(err)
+	case errors.Is(err, processing.ErrBusinessNotFound):
+		return http.StatusNotFound, processing.Reason(err)
+	case errors.Is(err, ErrUnauthenticated):
+		return http.StatusUnauthorized, REASON_UNAUTHORIZED
+	case errors.Is(err, ErrForbidden):
+		return http.StatusForbidden, REASON_FORBIDDEN
+	case apierrors.IsNotFound(err):
+		return http.StatusNotFound, REASON_NOT_FOUND
+	case apierrors.IsForbidden(err):
+		return http.StatusForbidden, REASON_FORBIDDEN
+	case apierrors.IsAlreadyExists(err):
+		return http.StatusConflict, REASON_ALREADY_EXISTS
+	default:
+		return http.StatusInternalServerError, REASON_INTERNAL_ERROR
+	}
+}
@@ -0,0 +1,154 @@
+package api
+
+import (
+	"context"
+	"crypto/tls"
+	"crypto/x509"
+	"fmt"
+	"io/ioutil"
+	"net/http"
+	"os"
+	"os/signal"
+	"resource-manager/config"
+	"sync/atomic"
+	"syscall"
+	"time"
+
+	log "k8s.io/klog/v2"
+)
+
+const (
+	DEFAULT_READ_HEADER_TIMEOUT   = 10 * time.Second
+	DEFAULT_READ_TIMEOUT          = 30 * time.Second
+	DEFAULT_WRITE_TIMEOUT         = 60 * time.Second
+	DEFAULT_IDLE_TIMEOUT          = 120 * time.Second
+	DEFAULT_SHUTDOWN_GRACE_PERIOD = 25 * time.Second
+	DEFAULT_SHUTDOWN_DELAY        = 5 * time.Second
+)
+
+// parseDuration разбор длительности из конфигурации; пустая строка - значение по умолчанию
+func parseDuration(name, value string, def time.Duration) (time.Duration, error) {
+	if value == "" {
+		return def, nil
+	}
+	d, err := time.ParseDuration(value)
+	if err != nil {
+		return 0, fmt.Errorf("%s: %s", name, err)
+	}
+	return d, nil
+}
+
+// newServer http-сервер с таймаутами из конфигурации
+func newServer(cfg *config.Config, handler http.Handler) (*http.Server, error) {
+	srv := &http.Server{Addr: cfg.Addr, Handler: handler}
+	if srv.Addr == "" {
+		srv.Addr = ":8080"
+	}
+
+	var err error
+	if srv.ReadHeaderTimeout, err = parseDuration("timeouts.read_header", cfg.Timeouts.ReadHeader, DEFAULT_READ_HEADER_TIMEOUT); err != nil {
+		return nil, err
+	}
+	if srv.ReadTimeout, err = parseDuration("timeouts.read", cfg.Timeouts.Read, DEFAULT_READ_TIMEOUT); err != nil {
+		return nil, err
+	}
+	if srv.WriteTimeout, err = parseDuration("timeouts.write", cfg.Timeouts.Write, DEFAULT_WRITE_TIMEOUT); err != nil {
+		return nil, err
+	}
+	if srv.IdleTimeout, err = parseDuration("timeouts.idle", cfg.Timeouts.Idle, DEFAULT_IDLE_TIMEOUT); err != nil {
+		return nil, err
+	}
+
+	srv.TLSConfig, err = newTLSConfig(cfg.TLS)
+	if err != nil {
+		return nil, err
+	}
+
+	return srv, nil
+}
+
+// newTLSConfig настройки TLS для проверки клиентских сертификатов;
+// nil, если client_ca_file не задан
+func newTLSConfig(c config.TLSType) (*tls.Config, error) {
+	if c.ClientCAFile == "" {
+		return nil, nil
+	}
+	if c.CertFile == "" || c.KeyFile == "" {
+		return nil, fmt.Errorf("tls: cert_file and key_file must be set with client_ca_file")
+	}
+
+	pem, err := ioutil.ReadFile(c.ClientCAFile)
+	if err != nil {
+		return nil, err
+	}
+	pool := x509.NewCertPool()
+	if !pool.AppendCertsFromPEM(pem) {
+		return nil, fmt.Errorf("tls: no certificates in %s", c.ClientCAFile)
+	}
+
+	// без require_client_cert сертификат необязателен, и можно использовать bearer-токен
+	clientAuth := tls.VerifyClientCertIfGiven
+	if c.RequireClientCert {
+		clientAuth = tls.RequireAndVerifyClientCert
+	}
+
+	return &tls.Config{ClientCAs: pool, ClientAuth: clientAuth}, nil
+}
+
+// serve запуск сервера и фоновых задач до получения SIGTERM или SIGINT
+// после сигнала /readyz возвращает 503, и в течение shutdown_delay сервер ещё принимает запросы,
+// пока под исключается из балансировки; затем сервер перестает принимать запросы и дожидается
+// завершения начатых и фоновых задач, но не дольше shutdown_grace_period
+func (s *server) serve(srv *http.Server, tlsCfg config.TLSType, delay, gracePeriod time.Duration) error {
+	stop := make(chan struct{})
+	done := s.processing.Start(stop)
+
+	errCh := make(chan error, 1)
+	go func() {
+		log.Infof("Service start, listen and serve: \"%s\"", srv.Addr)
+		if tlsCfg.CertFile != "" {
+			errCh <- srv.ListenAndServeTLS(tlsCfg.CertFile, tlsCfg.KeyFile)
+			return
+		}
+		errCh <- srv.ListenAndServe()
+	}()
+
+	signals := make(chan os.Signal, 1)
+	signal.Notify(signals, syscall.SIGTERM, syscall.SIGINT)
+	defer signal.Stop(signals)
+
+	select {
+	case err := <-errCh:
+		close(stop)
+		return err
+	case sig := <-signals:
+		log.Infof("Received %s, shutting down, grace period %s", sig, gracePeriod)
+	}
+
+	atomic.StoreInt32(&s.shuttingDown, 1)
+	close(stop)
+
+	if delay > 0 {
+		log.Infof("Shutdown: waiting %s before closing listeners", delay)
+		time.Sleep(delay)
+	}
+
+	ctx, cancel := context.WithTimeout(context.Background(), gracePeriod)
+	defer cancel()
+
+	// незавершенные запросы и фоновые задачи прерываются с выходом из процесса, это не ошибка запуска
+	if err := srv.Shutdown(ctx); err != nil {
+		log.Warningf("Shutdown: requests did not finish within the grace period: %s", err)
+		return nil
+	}
+
+	select {
+	case <-done:
+	case <-ctx.Done():
+		log.Warning("Shutdown: background tasks did not finish within the grace period")
+		return nil
+	}
+
+	log.Info("Service stopped")
+	return nil
+}
@@ -1,6 +1,9 @@
 package api
 
 import (
+	"resource-manager/processing"
+	"time"
+
 	corev1 "k8s.io/api/core/v1"
 	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
 )
@@ -8,9 +11,28 @@ import (
 type BodyResourceQuota struct {
 	MetaData metav1.ObjectMeta        `json:"metadata"`
 	Spec     corev1.ResourceQuotaSpec `json:"spec"`
+	// TTL срок выдачи квоты, например 72h
+	TTL string `json:"ttl,omitempty"`
+	// ExpiresAt время окончания выдачи квоты (RFC3339)
+	ExpiresAt *time.Time `json:"expiresAt,omitempty"`
 }
 
 type BodyLimitRange struct {
 	MetaData metav1.ObjectMeta     `json:"metadata"`
 	Spec     corev1.LimitRangeSpec `json:"spec"`
 }
+
+type DryRunReport struct {
+	*processing.AdmissionReport
+	Code   int    `json:"code"`
+	Reason string `json:"reason,omitempty"`
+}
+
+type ErrorResponse struct {
+	Code      int                            `json:"code"`
+	Reason    string                         `json:"reason"`
+	Message   string                         `json:"message"`
+	Details   []processing.ResourceShortfall `json:"details,omitempty"`
+	Resources []corev1.ResourceName          `json:"resources,omitempty"`
+	RequestID string                         `json:"requestId"`
+}
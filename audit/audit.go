@@ -0,0 +1,234 @@
+package audit
+
+import (
+	"fmt"
+	"sync"
+	"time"
+
+	"resource-manager/config"
+
+	corev1 "k8s.io/api/core/v1"
+	"k8s.io/apimachinery/pkg/api/resource"
+
+	log "k8s.io/klog/v2"
+)
+
+const (
+	SINK_FILE   = "file"
+	SINK_STDOUT = "stdout"
+	SINK_EVENTS = "events"
+
+	DEFAULT_BUFFER_SIZE = 1000
+	// DEFAULT_QUERY_LIMIT число записей в выборке, если limit не задан
+	DEFAULT_QUERY_LIMIT = 1000
+	// DEFAULT_FILE_MAX_SIZE размер файла приемника file, после которого он ротируется
+	DEFAULT_FILE_MAX_SIZE = "100Mi"
+	// DEFAULT_FILE_MAX_BACKUPS число хранимых ротированных файлов
+	DEFAULT_FILE_MAX_BACKUPS = 3
+
+	// VERDICT_OK операция выполнена или может быть выполнена (dryRun)
+	VERDICT_OK = "ok"
+)
+
+// Event запись аудита об изменении квоты или limitrange
+type Event struct {
+	Time      time.Time `json:"time"`
+	RequestID string    `json:"requestId,omitempty"`
+	User      string    `json:"user,omitempty"`
+	Groups    []string  `json:"groups,omitempty"`
+	// Operation create, update или delete
+	Operation string `json:"operation"`
+	// Resource resourcequotas или limitranges
+	Resource  string `json:"resource"`
+	Namespace string `json:"namespace"`
+	Name      string `json:"name,omitempty"`
+	Business  string `json:"business,omitempty"`
+	DryRun    bool   `json:"dryRun,omitempty"`
+	// OldHard spec.hard квоты до изменения
+	OldHard corev1.ResourceList `json:"oldHard,omitempty"`
+	// NewHard запрошенный spec.hard квоты
+	NewHard corev1.ResourceList `json:"newHard,omitempty"`
+	// Available доступные ресурсы колонны на момент решения
+	Available corev1.ResourceList `json:"available,omitempty"`
+	// Verdict ok или текст ошибки
+	Verdict string `json:"verdict"`
+	// Code http-код ответа (для dryRun - код, с которым был бы выполнен запрос)
+	Code   int    `json:"code"`
+	Reason string `json:"reason,omitempty"`
+}
+
+// Filter условия выборки записей аудита; пустые поля не учитываются
+type Filter struct {
+	Namespace string
+	Business  string
+	User      string
+	Operation string
+	Resource  string
+	// Rejected true - только отказы, false - только выполненные операции
+	Rejected *bool
+	Since    time.Time
+	Until    time.Time
+	// Limit максимальное число записей (последние по времени); 0 - DEFAULT_QUERY_LIMIT
+	Limit int
+}
+
+// Sink приемник записей аудита
+type Sink interface {
+	Write(e *Event) error
+}
+
+// Reader приемник, из которого можно прочитать записи аудита
+type Reader interface {
+	Query(f Filter) ([]Event, error)
+}
+
+// EventRecorder запись событий kubernetes для приемника events; реализуется *processing.Service,
+// который записывает события в фоне через свою очередь, поэтому Write не ждет kubernetes
+type EventRecorder interface {
+	RecordEvent(ref *corev1.ObjectReference, eventType, reason, message string) error
+}
+
+// Logger запись записей аудита в приемники и их выборка
+type Logger struct {
+	sinks  []Sink
+	buffer *ring
+}
+
+// New приемники аудита по конфигурации; recorder используется приемником events
+func New(c config.AuditType, recorder EventRecorder) (*Logger, error) {
+	l := &Logger{buffer: &ring{size: DEFAULT_BUFFER_SIZE}}
+	if c.BufferSize > 0 {
+		l.buffer.size = c.BufferSize
+	}
+
+	for _, name := range c.Sinks {
+		switch name {
+		case SINK_FILE:
+			sink, err := newFileSink(c)
+			if err != nil {
+				return nil, err
+			}
+			l.sinks = append(l.sinks, sink)
+		case SINK_STDOUT:
+			l.sinks = append(l.sinks, &stdoutSink{})
+		case SINK_EVENTS:
+			l.sinks = append(l.sinks, &eventsSink{recorder: recorder})
+		default:
+			return nil, fmt.Errorf("audit: unknown sink %q", name)
+		}
+	}
+
+	return l, nil
+}
+
+// newFileSink приемник file по конфигурации
+func newFileSink(c config.AuditType) (*fileSink, error) {
+	if c.File == "" {
+		return nil, fmt.Errorf("audit: file is not set for sink %s", SINK_FILE)
+	}
+
+	maxSize := DEFAULT_FILE_MAX_SIZE
+	if c.FileMaxSize != "" {
+		maxSize = c.FileMaxSize
+	}
+	q, err := resource.ParseQuantity(maxSize)
+	if err != nil {
+		return nil, fmt.Errorf("audit: file_max_size: %s", err)
+	}
+
+	maxBackups := DEFAULT_FILE_MAX_BACKUPS
+	if c.FileMaxBackups != 0 {
+		maxBackups = c.FileMaxBackups
+	}
+	if maxBackups < 0 {
+		return nil, fmt.Errorf("audit: file_max_backups must not be negative")
+	}
+
+	return &fileSink{path: c.File, maxSize: q.Value(), maxBackups: maxBackups}, nil
+}
+
+// Record запись события во все приемники и в буфер последних записей
+// ошибки приемников записываются в лог и не прерывают обработку запроса
+func (l *Logger) Record(e *Event) {
+	if e.Time.IsZero() {
+		e.Time = time.Now()
+	}
+
+	l.buffer.add(*e)
+
+	for _, sink := range l.sinks {
+		if err := sink.Write(e); err != nil {
+			log.Errorf("Audit: write event: %s", err)
+		}
+	}
+}
+
+// Query выборка записей аудита
+// записи читаются из приемника, поддерживающего чтение (file), иначе из буфера последних записей;
+// без f.Limit возвращается не больше DEFAULT_QUERY_LIMIT последних записей
+func (l *Logger) Query(f Filter) ([]Event, error) {
+	if f.Limit <= 0 {
+		f.Limit = DEFAULT_QUERY_LIMIT
+	}
+
+	for _, sink := range l.sinks {
+		if reader, ok := sink.(Reader); ok {
+			return reader.Query(f)
+		}
+	}
+	return l.buffer.Query(f)
+}
+
+// match подходит ли запись под условия фильтра
+func (f Filter) match(e *Event) bool {
+	switch {
+	case f.Namespace != "" && e.Namespace != f.Namespace,
+		f.Business != "" && e.Business != f.Business,
+		f.User != "" && e.User != f.User,
+		f.Operation != "" && e.Operation != f.Operation,
+		f.Resource != "" && e.Resource != f.Resource,
+		f.Rejected != nil && *f.Rejected != (e.Verdict != VERDICT_OK),
+		!f.Since.IsZero() && e.Time.Before(f.Since),
+		!f.Until.IsZero() && e.Time.After(f.Until):
+		return false
+	}
+	return true
+}
+
+// limit последние f.Limit записей
+func (f Filter) limit(events []Event) []Event {
+	if f.Limit > 0 && len(events) > f.Limit {
+		return events[len(events)-f.Limit:]
+	}
+	return events
+}
+
+// ring буфер последних записей аудита
+type ring struct {
+	mu     sync.Mutex
+	size   int
+	events []Event
+}
+
+func (r *ring) add(e Event) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+
+	r.events = append(r.events, e)
+	if len(r.events) > r.size {
+		r.events = r.events[len(r.events)-r.size:]
+	}
+}
+
+func (r *ring) Query(f Filter) ([]Event, error) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+
+	result := []Event{}
+	for i := range r.events {
+		if f.match(&r.events[i]) {
+			result = append(result, r.events[i])
+		}
+	}
+	return f.limit(result), nil
+}
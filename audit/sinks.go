@@ -0,0 +1,189 @@
+package audit
+
+import (
+	"bufio"
+	"encoding/json"
+	"fmt"
+	"os"
+	"strings"
+	"sync"
+
+	corev1 "k8s.io/api/core/v1"
+
+	log "k8s.io/klog/v2"
+)
+
+// fileSink запись аудита в файл в формате JSON lines
+// при превышении maxSize файл переименовывается в <path>.1, старые файлы сдвигаются до <path>.<maxBackups>
+type fileSink struct {
+	mu         sync.Mutex
+	path       string
+	maxSize    int64
+	maxBackups int
+}
+
+// stdoutSink запись аудита в stdout в формате JSON lines
+type stdoutSink struct {
+	mu sync.Mutex
+}
+
+// eventsSink запись аудита в события kubernetes в namespace операции
+// события записываются через очередь событий сервиса (см. EventRecorder)
+type eventsSink struct {
+	recorder EventRecorder
+}
+
+func (s *fileSink) Write(e *Event) error {
+	b, err := json.Marshal(e)
+	if err != nil {
+		return err
+	}
+	b = append(b, '\n')
+
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	if err := s.rotate(int64(len(b))); err != nil {
+		log.Errorf("Audit: rotate file %s: %s", s.path, err)
+	}
+
+	f, err := os.OpenFile(s.path, os.O_APPEND|os.O_CREATE|os.O_WRONLY, 0644)
+	if err != nil {
+		return err
+	}
+	defer f.Close()
+
+	_, err = f.Write(b)
+	return err
+}
+
+// rotate переименование файла, если после записи n байт он превысит maxSize
+// вызывается под s.mu
+func (s *fileSink) rotate(n int64) error {
+	if s.maxSize <= 0 {
+		return nil
+	}
+
+	info, err := os.Stat(s.path)
+	if os.IsNotExist(err) {
+		return nil
+	}
+	if err != nil {
+		return err
+	}
+	if info.Size() == 0 || info.Size()+n <= s.maxSize {
+		return nil
+	}
+
+	if s.maxBackups <= 0 {
+		return os.Remove(s.path)
+	}
+
+	// самый старый файл удаляется, остальные сдвигаются на один номер
+	if err := os.Remove(s.backup(s.maxBackups)); err != nil && !os.IsNotExist(err) {
+		return err
+	}
+	for i := s.maxBackups - 1; i >= 1; i-- {
+		if err := os.Rename(s.backup(i), s.backup(i+1)); err != nil && !os.IsNotExist(err) {
+			return err
+		}
+	}
+	return os.Rename(s.path, s.backup(1))
+}
+
+// backup имя файла с номером i; 0 - текущий файл
+func (s *fileSink) backup(i int) string {
+	if i == 0 {
+		return s.path
+	}
+	return fmt.Sprintf("%s.%d", s.path, i)
+}
+
+// Query выборка последних f.Limit записей из файла и, если их не хватает, из предыдущих файлов
+// файлы читаются без блокировки записи: записи дописываются в конец,
+// а открытый файл остается доступным для чтения после ротации
+func (s *fileSink) Query(f Filter) ([]Event, error) {
+	result := []Event{}
+	for i := 0; i <= s.maxBackups; i++ {
+		events, err := s.queryFile(s.backup(i), f)
+		if err != nil {
+			return nil, err
+		}
+		result = f.limit(append(events, result...))
+		if f.Limit > 0 && len(result) >= f.Limit {
+			break
+		}
+	}
+	return result, nil
+}
+
+// queryFile выборка последних f.Limit записей из файла path
+func (s *fileSink) queryFile(path string, f Filter) ([]Event, error) {
+	file, err := os.Open(path)
+	if os.IsNotExist(err) {
+		return []Event{}, nil
+	}
+	if err != nil {
+		return nil, err
+	}
+	defer file.Close()
+
+	result := []Event{}
+	scanner := bufio.NewScanner(file)
+	scanner.Buffer(make([]byte, 64*1024), 1024*1024)
+	for scanner.Scan() {
+		var e Event
+		// последняя строка может быть записана не полностью
+		if err := json.Unmarshal(scanner.Bytes(), &e); err != nil {
+			continue
+		}
+		if f.match(&e) {
+			result = append(result, e)
+			// в памяти хранится не больше Limit последних записей
+			result = f.limit(result)
+		}
+	}
+	if err := scanner.Err(); err != nil {
+		return nil, err
+	}
+
+	return result, nil
+}
+
+func (s *stdoutSink) Write(e *Event) error {
+	b, err := json.Marshal(e)
+	if err != nil {
+		return err
+	}
+
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	_, err = os.Stdout.Write(append(b, '\n'))
+	return err
+}
+
+// Write запись события; recorder ставит событие в очередь и не ждет kubernetes
+func (s *eventsSink) Write(e *Event) error {
+	ref := &corev1.ObjectReference{Kind: "ResourceQuota", APIVersion: "v1", Namespace: e.Namespace, Name: e.Name}
+	if e.Resource == "limitranges" {
+		ref.Kind = "LimitRange"
+	}
+
+	eventType := corev1.EventTypeNormal
+	if e.Verdict != VERDICT_OK {
+		eventType = corev1.EventTypeWarning
+	}
+
+	user := e.User
+	if user == "" {
+		user = "unknown"
+	}
+
+	message := fmt.Sprintf("%s %s %s/%s: %s", user, e.Operation, strings.ToLower(ref.Kind), e.Name, e.Verdict)
+	if e.DryRun {
+		message += " (dry run)"
+	}
+
+	return s.recorder.RecordEvent(ref, eventType, "Audit", message)
+}
@@ -0,0 +1,117 @@
+package audit
+
+import (
+	"fmt"
+	"os"
+	"path/filepath"
+	"reflect"
+	"sync"
+	"testing"
+	"time"
+
+	"resource-manager/config"
+
+	corev1 "k8s.io/api/core/v1"
+)
+
+func TestFileSinkRotation(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "audit.jsonl")
+	s := &fileSink{path: path, maxSize: 1024, maxBackups: 2}
+
+	for i := 0; i < 100; i++ {
+		e := &Event{Time: time.Now(), Operation: "create", Resource: "resourcequotas", Namespace: fmt.Sprintf("team-%d", i), Verdict: VERDICT_OK}
+		if err := s.Write(e); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	for i := 0; i <= 3; i++ {
+		info, err := os.Stat(s.backup(i))
+		if i == 3 {
+			if !os.IsNotExist(err) {
+				t.Errorf("%s exists, want at most %d backups", s.backup(i), s.maxBackups)
+			}
+			continue
+		}
+		if err != nil {
+			t.Fatal(err)
+		}
+		if info.Size() > s.maxSize {
+			t.Errorf("%s size %d exceeds %d", s.backup(i), info.Size(), s.maxSize)
+		}
+	}
+
+	tests := []struct {
+		name  string
+		limit int
+		first string
+	}{
+		// последние записи читаются из текущего файла и, если их не хватает, из предыдущих
+		{name: "current file", limit: 2, first: "team-98"},
+		{name: "across backups", limit: 10, first: "team-90"},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			events, err := s.Query(Filter{Limit: tt.limit})
+			if err != nil {
+				t.Fatal(err)
+			}
+			if len(events) != tt.limit {
+				t.Fatalf("got %d events, want %d", len(events), tt.limit)
+			}
+			if events[0].Namespace != tt.first || events[len(events)-1].Namespace != "team-99" {
+				t.Errorf("got %s..%s, want %s..team-99", events[0].Namespace, events[len(events)-1].Namespace, tt.first)
+			}
+		})
+	}
+}
+
+func TestLoggerQueryDefaultLimit(t *testing.T) {
+	l, err := New(config.AuditType{BufferSize: DEFAULT_QUERY_LIMIT + 10}, nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	for i := 0; i < DEFAULT_QUERY_LIMIT+10; i++ {
+		l.Record(&Event{Operation: "create", Verdict: VERDICT_OK})
+	}
+
+	events, err := l.Query(Filter{})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(events) != DEFAULT_QUERY_LIMIT {
+		t.Errorf("got %d events, want %d", len(events), DEFAULT_QUERY_LIMIT)
+	}
+}
+
+// fakeRecorder записанные события kubernetes
+type fakeRecorder struct {
+	mu     sync.Mutex
+	events []string
+}
+
+func (r *fakeRecorder) RecordEvent(ref *corev1.ObjectReference, eventType, reason, message string) error {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	r.events = append(r.events, fmt.Sprintf("%s %s/%s %s %s: %s", ref.Kind, ref.Namespace, ref.Name, eventType, reason, message))
+	return nil
+}
+
+func TestEventsSink(t *testing.T) {
+	recorder := &fakeRecorder{}
+	l, err := New(config.AuditType{Sinks: []string{SINK_EVENTS}}, recorder)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	l.Record(&Event{User: "alice", Operation: "update", Resource: "resourcequotas", Namespace: "team-a", Name: "cap-resource", Verdict: VERDICT_OK})
+	l.Record(&Event{Operation: "delete", Resource: "limitranges", Namespace: "team-b", Name: "default", Verdict: "forbidden", DryRun: true})
+
+	want := []string{
+		"ResourceQuota team-a/cap-resource Normal Audit: alice update resourcequota/cap-resource: ok",
+		"LimitRange team-b/default Warning Audit: unknown delete limitrange/default: forbidden (dry run)",
+	}
+	if !reflect.DeepEqual(recorder.events, want) {
+		t.Errorf("events = %q, want %q", recorder.events, want)
+	}
+}
@@ -1,18 +1,129 @@
 package config
 
 type Config struct {
-	Addr       string         `yaml:"listen_addr"`
-	Processing ProcessingType `yaml:"processing"`
-	Prometheus PrometheusType `yaml:"prometheus"`
+	Addr                string         `yaml:"listen_addr"`
+	Timeouts            TimeoutsType   `yaml:"timeouts"`
+	ShutdownGracePeriod string         `yaml:"shutdown_grace_period"`
+	ShutdownDelay       string         `yaml:"shutdown_delay"`
+	TLS                 TLSType        `yaml:"tls"`
+	Auth                AuthType       `yaml:"auth"`
+	Audit               AuditType      `yaml:"audit"`
+	Processing          ProcessingType `yaml:"processing"`
+	Prometheus          PrometheusType `yaml:"prometheus"`
+}
+
+type TLSType struct {
+	CertFile          string `yaml:"cert_file"`
+	KeyFile           string `yaml:"key_file"`
+	ClientCAFile      string `yaml:"client_ca_file"`
+	RequireClientCert bool   `yaml:"require_client_cert"`
+}
+
+type AuthType struct {
+	Enabled        bool                `yaml:"enabled"`
+	Audiences      []string            `yaml:"audiences"`
+	CacheTTL       string              `yaml:"cache_ttl"`
+	Authorizer     string              `yaml:"authorizer"`
+	AdminGroups    []string            `yaml:"admin_groups"`
+	BusinessGroups map[string][]string `yaml:"business_groups"`
+	Impersonate    bool                `yaml:"impersonate"`
+}
+
+type AuditType struct {
+	Sinks          []string `yaml:"sinks"`
+	File           string   `yaml:"file"`
+	FileMaxSize    string   `yaml:"file_max_size"`
+	FileMaxBackups int      `yaml:"file_max_backups"`
+	BufferSize     int      `yaml:"buffer_size"`
+}
+
+type TimeoutsType struct {
+	ReadHeader string `yaml:"read_header"`
+	Read       string `yaml:"read"`
+	Write      string `yaml:"write"`
+	Idle       string `yaml:"idle"`
 }
 
 type ProcessingType struct {
-	DefaultResourceQuotaName    string                 `yaml:"default_resource_quota_name"`
-	InfraFee                    string                 `yaml:"infra_fee"`
-	InfraCustomers              []string               `yaml:"infra_customers"`
-	CpuOversubscription         string                 `yaml:"cpu_oversubscription"`
-	BusinessAnnotationFieldName string                 `yaml:"business_annotation_field_name"`
-	DefaultLimitRange           map[string]interface{} `yaml:"default_limitrange"`
+	DefaultResourceQuotaName    string                          `yaml:"default_resource_quota_name"`
+	InfraFee                    string                          `yaml:"infra_fee"`
+	InfraCustomers              []string                        `yaml:"infra_customers"`
+	CpuOversubscription         string                          `yaml:"cpu_oversubscription"`
+	BusinessAnnotationFieldName string                          `yaml:"business_annotation_field_name"`
+	DefaultLimitRange           map[string]interface{}          `yaml:"default_limitrange"`
+	AdmissionLock               AdmissionLockType               `yaml:"admission_lock"`
+	Ledger                      LedgerType                      `yaml:"reservation_ledger"`
+	HardSource                  string                          `yaml:"hard_source"`
+	HardSourceCrossCheck        bool                            `yaml:"hard_source_cross_check"`
+	Resources                   map[string]ResourceType         `yaml:"resources"`
+	UnmanagedResources          UnmanagedResourcesType          `yaml:"unmanaged_resources"`
+	BusinessOverrides           map[string]BusinessOverrideType `yaml:"business_overrides"`
+	Oversubscription            map[string]string               `yaml:"oversubscription"`
+	BurstPool                   BurstPoolType                   `yaml:"burst_pool"`
+	GrantExpiry                 GrantExpiryType                 `yaml:"grant_expiry"`
+	Drift                       DriftType                       `yaml:"drift"`
+}
+
+type DriftType struct {
+	Interval           string `yaml:"interval"`
+	AnnotateNamespaces bool   `yaml:"annotate_namespaces"`
+}
+
+type GrantExpiryType struct {
+	MaxTTL            string `yaml:"max_ttl"`
+	ReconcileInterval string `yaml:"reconcile_interval"`
+}
+
+type BurstPoolType struct {
+	Enabled         bool              `yaml:"enabled"`
+	Source          string            `yaml:"source"`
+	Pool            map[string]string `yaml:"pool"`
+	Percent         string            `yaml:"percent"`
+	MaxDuration     string            `yaml:"max_duration"`
+	ReclaimInterval string            `yaml:"reclaim_interval"`
+}
+
+type BusinessOverrideType struct {
+	InfraFee            string                          `yaml:"infra_fee"`
+	CpuOversubscription string                          `yaml:"cpu_oversubscription"`
+	Resources           map[string]ResourceOverrideType `yaml:"resources"`
+	CapacityPolicy      string                          `yaml:"capacity_policy"`
+	Pool                map[string]string               `yaml:"pool"`
+	Weight              string                          `yaml:"weight"`
+}
+
+type ResourceOverrideType struct {
+	Oversubscription string `yaml:"oversubscription"`
+	InfraFeeExempt   *bool  `yaml:"infra_fee_exempt"`
+}
+
+type UnmanagedResourcesType struct {
+	DefaultPolicy string                        `yaml:"default_policy"`
+	Resources     map[string]ResourcePolicyType `yaml:"resources"`
+}
+
+type ResourcePolicyType struct {
+	Policy string `yaml:"policy"`
+	Max    string `yaml:"max"`
+}
+
+type ResourceType struct {
+	AssetQuery     string `yaml:"asset_query"`
+	HardQuery      string `yaml:"hard_query"`
+	InfraFeeExempt bool   `yaml:"infra_fee_exempt"`
+}
+
+type LedgerType struct {
+	NamespaceLabel string `yaml:"namespace_label"`
+	QuotaLabel     string `yaml:"quota_label"`
+	TTL            string `yaml:"ttl"`
+}
+
+type AdmissionLockType struct {
+	Type           string `yaml:"type"`
+	LeaseNamespace string `yaml:"lease_namespace"`
+	LeaseDuration  string `yaml:"lease_duration"`
+	Timeout        string `yaml:"timeout"`
 }
 
 type PrometheusType struct {
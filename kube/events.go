@@ -0,0 +1,78 @@
+package kube
+
+import (
+	"fmt"
+	"time"
+
+	corev1 "k8s.io/api/core/v1"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+)
+
+const (
+	// EVENT_SOURCE компонент, от имени которого записываются события
+	EVENT_SOURCE = "resource-manager"
+
+	// EVENT_QUOTA_GRANTED квота выдана или изменена
+	EVENT_QUOTA_GRANTED = "QuotaGranted"
+	// EVENT_QUOTA_REJECTED в выдаче или изменении квоты отказано
+	EVENT_QUOTA_REJECTED = "QuotaRejected"
+	// EVENT_QUOTA_SHRUNK квота уменьшена или удалена сервисом (возврат в burst-пул, окончание срока выдачи)
+	EVENT_QUOTA_SHRUNK = "QuotaShrunk"
+
+	// максимальная длина сообщения события
+	eventMessageLimit = 1024
+)
+
+// QuotaReference ссылка на квоту для события
+func QuotaReference(rq *corev1.ResourceQuota) *corev1.ObjectReference {
+	return &corev1.ObjectReference{
+		Kind:            "ResourceQuota",
+		APIVersion:      "v1",
+		Namespace:       rq.Namespace,
+		Name:            rq.Name,
+		UID:             rq.UID,
+		ResourceVersion: rq.ResourceVersion,
+	}
+}
+
+// NamespaceReference ссылка на namespace для события
+// событие записывается в этот же namespace и видно в kubectl get events -n <ns>
+func NamespaceReference(ns string) *corev1.ObjectReference {
+	return &corev1.ObjectReference{
+		Kind:       "Namespace",
+		APIVersion: "v1",
+		Namespace:  ns,
+		Name:       ns,
+	}
+}
+
+// RecordEvent запись события kubernetes об объекте ref в namespace объекта
+// eventType - corev1.EventTypeNormal или corev1.EventTypeWarning
+func (c *Client) RecordEvent(ref *corev1.ObjectReference, eventType, reason, message string) error {
+	if len(message) > eventMessageLimit {
+		message = message[:eventMessageLimit-3] + "..."
+	}
+
+	// имя в формате event recorder из client-go
+	prefix := ref.Name
+	if prefix == "" {
+		prefix = EVENT_SOURCE
+	}
+
+	now := time.Now()
+	_, err := c.CreateEvent(&corev1.Event{
+		ObjectMeta: metav1.ObjectMeta{
+			Name:      fmt.Sprintf("%v.%x", prefix, now.UnixNano()),
+			Namespace: ref.Namespace,
+		},
+		InvolvedObject: *ref,
+		Reason:         reason,
+		Message:        message,
+		Type:           eventType,
+		Source:         corev1.EventSource{Component: EVENT_SOURCE},
+		FirstTimestamp: metav1.NewTime(now),
+		LastTimestamp:  metav1.NewTime(now),
+		Count:          1,
+	})
+	return err
+}
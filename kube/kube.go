@@ -2,23 +2,52 @@ package kube
 
 import (
 	"context"
+	"fmt"
+	"net/http"
 	"path/filepath"
+	"sort"
+	"strings"
+	"sync"
 
+	"resource-manager/metrics"
+
+	authenticationv1 "k8s.io/api/authentication/v1"
+	authorizationv1 "k8s.io/api/authorization/v1"
+	coordinationv1 "k8s.io/api/coordination/v1"
 	corev1 "k8s.io/api/core/v1"
 	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
 	"k8s.io/client-go/kubernetes"
 	"k8s.io/client-go/rest"
 	"k8s.io/client-go/tools/clientcmd"
+	clientmetrics "k8s.io/client-go/tools/metrics"
 	"k8s.io/client-go/util/homedir"
 )
 
-type Clientset struct {
-	*kubernetes.Clientset
+// impersonatedClientsSize максимальное число клиентов от имени пользователей в кэше
+const impersonatedClientsSize = 1000
+
+// User пользователь, от имени которого выполняется запрос в kubernetes API (impersonation)
+type User struct {
+	Name   string
+	Groups []string
+	Extra  map[string][]string
 }
 
-var clientset *Clientset
+// Client клиент kubernetes API
+type Client struct {
+	clientset kubernetes.Interface
+	// restConfig конфигурация clientset для запросов от имени пользователя;
+	// nil - запросы от имени пользователя выполняются через clientset без impersonation
+	restConfig *rest.Config
+
+	mu sync.Mutex
+	// transport общий транспорт клиентов от имени пользователей: соединения с API не создаются на каждый запрос
+	transport http.RoundTripper
+	// impersonated клиенты от имени пользователей по ключу impersonationKey
+	impersonated map[string]kubernetes.Interface
+}
 
-func NewClientset() (*Clientset, error) {
+func newRestConfig() (*rest.Config, error) {
 	config, err := rest.InClusterConfig()
 	if err != nil {
 		var kubeconfig string
@@ -33,83 +62,300 @@ func NewClientset() (*Clientset, error) {
 			return nil, err
 		}
 	}
+	return config, nil
+}
+
+// NewClient клиент для clientset (в том числе fake.NewSimpleClientset в тестах)
+func NewClient(clientset kubernetes.Interface, restConfig *rest.Config) *Client {
+	return &Client{
+		clientset:    clientset,
+		restConfig:   restConfig,
+		impersonated: make(map[string]kubernetes.Interface),
+	}
+}
+
+// New клиент по in-cluster конфигурации или ~/.kube/config
+func New() (*Client, error) {
+	clientmetrics.Register(clientmetrics.RegisterOpts{RequestResult: requestResult{}})
+
+	restConfig, err := newRestConfig()
+	if err != nil {
+		return nil, err
+	}
+
+	clientset, err := kubernetes.NewForConfig(restConfig)
+	if err != nil {
+		return nil, err
+	}
+	return NewClient(clientset, restConfig), nil
+}
+
+// clientAs клиент, выполняющий запросы от имени пользователя as
+// при nil используется ServiceAccount сервиса; клиенты пользователей кэшируются
+// и используют общий транспорт, заголовки impersonation добавляются к каждому запросу
+func (c *Client) clientAs(as *User) (kubernetes.Interface, error) {
+	if as == nil || c.restConfig == nil {
+		return c.clientset, nil
+	}
+
+	key := impersonationKey(as)
+
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	if client, ok := c.impersonated[key]; ok {
+		return client, nil
+	}
+
+	if c.transport == nil {
+		transport, err := rest.TransportFor(c.restConfig)
+		if err != nil {
+			return nil, err
+		}
+		c.transport = transport
+	}
+
+	client, err := kubernetes.NewForConfig(impersonatedConfig(c.restConfig, c.transport, as))
+	if err != nil {
+		return nil, err
+	}
+
+	// при переполнении кэш очищается целиком
+	if len(c.impersonated) >= impersonatedClientsSize {
+		c.impersonated = make(map[string]kubernetes.Interface)
+	}
+	c.impersonated[key] = client
+	return client, nil
+}
+
+// impersonatedConfig конфигурация клиента от имени пользователя as поверх транспорта transport
+// transport уже выполняет TLS и аутентификацию сервиса, поэтому эти параметры из конфигурации убираются
+func impersonatedConfig(restConfig *rest.Config, transport http.RoundTripper, as *User) *rest.Config {
+	config := rest.CopyConfig(restConfig)
+	config.TLSClientConfig = rest.TLSClientConfig{}
+	config.BearerToken = ""
+	config.BearerTokenFile = ""
+	config.Username = ""
+	config.Password = ""
+	config.AuthProvider = nil
+	config.AuthConfigPersister = nil
+	config.ExecProvider = nil
+	config.WrapTransport = nil
+	config.Dial = nil
+	config.Proxy = nil
+	config.Transport = transport
+	config.Impersonate = rest.ImpersonationConfig{
+		UserName: as.Name,
+		Groups:   as.Groups,
+		Extra:    as.Extra,
+	}
+	return config
+}
+
+// impersonationKey ключ кэша клиентов: имя, группы и extra пользователя
+// значения экранируются, чтобы разные пользователи не получили одинаковый ключ
+func impersonationKey(as *User) string {
+	groups := append([]string{}, as.Groups...)
+	sort.Strings(groups)
+
+	keys := make([]string, 0, len(as.Extra))
+	for k := range as.Extra {
+		keys = append(keys, k)
+	}
+	sort.Strings(keys)
 
-	clientset, err := kubernetes.NewForConfig(config)
-	return &Clientset{clientset}, err
+	key := fmt.Sprintf("%q %q", as.Name, groups)
+	for _, k := range keys {
+		values := append([]string{}, as.Extra[k]...)
+		sort.Strings(values)
+		key += fmt.Sprintf(" %q=%q", k, values)
+	}
+	return key
 }
 
-func Init() (err error) {
-	clientset, err = NewClientset()
-	return
+// requestResult учет результатов запросов клиента в kubernetes API
+type requestResult struct{}
+
+// Increment вызывается клиентом после каждого запроса;
+// неуспешные запросы учитываются в метрике ошибок
+func (requestResult) Increment(ctx context.Context, code, method, host string) {
+	if strings.HasPrefix(code, "2") {
+		return
+	}
+	metrics.KubeAPIErrors.WithLabelValues(method, code).Inc()
 }
 
-func GetNamespace(nsName string) (*corev1.Namespace, error) {
-	return clientset.CoreV1().Namespaces().Get(
+// Ping проверка доступности kubernetes API запросом /version с ограничением по ctx
+func (c *Client) Ping(ctx context.Context) error {
+	return c.clientset.Discovery().RESTClient().Get().AbsPath("/version").Do(ctx).Error()
+}
+
+func (c *Client) CreateTokenReview(review *authenticationv1.TokenReview) (*authenticationv1.TokenReview, error) {
+	return c.clientset.AuthenticationV1().TokenReviews().Create(
+		context.Background(),
+		review,
+		metav1.CreateOptions{},
+	)
+}
+
+func (c *Client) CreateSubjectAccessReview(review *authorizationv1.SubjectAccessReview) (*authorizationv1.SubjectAccessReview, error) {
+	return c.clientset.AuthorizationV1().SubjectAccessReviews().Create(
+		context.Background(),
+		review,
+		metav1.CreateOptions{},
+	)
+}
+
+func (c *Client) GetNamespace(nsName string) (*corev1.Namespace, error) {
+	return c.clientset.CoreV1().Namespaces().Get(
 		context.Background(),
 		nsName,
 		metav1.GetOptions{},
 	)
 }
 
-func GetNamespaces() (*corev1.NamespaceList, error) {
-	return clientset.CoreV1().Namespaces().List(
+func (c *Client) GetNamespaces() (*corev1.NamespaceList, error) {
+	return c.clientset.CoreV1().Namespaces().List(
 		context.Background(),
 		metav1.ListOptions{},
 	)
 }
 
-func CreateQuota(rq *corev1.ResourceQuota) (*corev1.ResourceQuota, error) {
-	return clientset.CoreV1().ResourceQuotas(rq.Namespace).Create(
+func (c *Client) UpdateNamespace(ns *corev1.Namespace) (*corev1.Namespace, error) {
+	return c.clientset.CoreV1().Namespaces().Update(
+		context.Background(),
+		ns,
+		metav1.UpdateOptions{},
+	)
+}
+
+// CreateEvent создание события kubernetes в namespace события
+func (c *Client) CreateEvent(event *corev1.Event) (*corev1.Event, error) {
+	return c.clientset.CoreV1().Events(event.Namespace).Create(
+		context.Background(),
+		event,
+		metav1.CreateOptions{},
+	)
+}
+
+// CreateQuota создание квоты; при as != nil - от имени пользователя as
+func (c *Client) CreateQuota(rq *corev1.ResourceQuota, as *User) (*corev1.ResourceQuota, error) {
+	client, err := c.clientAs(as)
+	if err != nil {
+		return nil, err
+	}
+	return client.CoreV1().ResourceQuotas(rq.Namespace).Create(
 		context.Background(),
 		rq,
 		metav1.CreateOptions{},
 	)
 }
 
-func UpdateQuota(rq *corev1.ResourceQuota) (*corev1.ResourceQuota, error) {
-	return clientset.CoreV1().ResourceQuotas(rq.Namespace).Update(
+// UpdateQuota изменение квоты; при as != nil - от имени пользователя as
+func (c *Client) UpdateQuota(rq *corev1.ResourceQuota, as *User) (*corev1.ResourceQuota, error) {
+	client, err := c.clientAs(as)
+	if err != nil {
+		return nil, err
+	}
+	return client.CoreV1().ResourceQuotas(rq.Namespace).Update(
 		context.Background(),
 		rq,
 		metav1.UpdateOptions{},
 	)
 }
 
-func DeleteQuota(rq *corev1.ResourceQuota) error {
-	return clientset.CoreV1().ResourceQuotas(rq.Namespace).Delete(
+func (c *Client) DeleteQuota(rq *corev1.ResourceQuota, as *User) error {
+	client, err := c.clientAs(as)
+	if err != nil {
+		return err
+	}
+	return client.CoreV1().ResourceQuotas(rq.Namespace).Delete(
 		context.Background(),
 		rq.GetName(),
 		metav1.DeleteOptions{},
 	)
 }
 
-func GetQuota(name, ns string) (*corev1.ResourceQuota, error) {
-	return clientset.CoreV1().ResourceQuotas(ns).Get(
+func (c *Client) GetQuota(name, ns string) (*corev1.ResourceQuota, error) {
+	return c.clientset.CoreV1().ResourceQuotas(ns).Get(
 		context.Background(),
 		name,
 		metav1.GetOptions{},
 	)
 }
 
-func CreateLimitRanges(lr *corev1.LimitRange) (*corev1.LimitRange, error) {
-	return clientset.CoreV1().LimitRanges(lr.Namespace).Create(
+func (c *Client) GetAllQuotas() (*corev1.ResourceQuotaList, error) {
+	return c.clientset.CoreV1().ResourceQuotas(metav1.NamespaceAll).List(
+		context.Background(),
+		metav1.ListOptions{},
+	)
+}
+
+func (c *Client) GetLimitRange(name, ns string) (*corev1.LimitRange, error) {
+	return c.clientset.CoreV1().LimitRanges(ns).Get(
+		context.Background(),
+		name,
+		metav1.GetOptions{},
+	)
+}
+
+// CreateLimitRanges создание LimitRange; при as != nil - от имени пользователя as
+func (c *Client) CreateLimitRanges(lr *corev1.LimitRange, as *User) (*corev1.LimitRange, error) {
+	client, err := c.clientAs(as)
+	if err != nil {
+		return nil, err
+	}
+	return client.CoreV1().LimitRanges(lr.Namespace).Create(
 		context.Background(),
 		lr,
 		metav1.CreateOptions{},
 	)
 }
 
-func UpdateLimitRanges(lr *corev1.LimitRange) (*corev1.LimitRange, error) {
-	return clientset.CoreV1().LimitRanges(lr.Namespace).Update(
+func (c *Client) UpdateLimitRanges(lr *corev1.LimitRange, as *User) (*corev1.LimitRange, error) {
+	client, err := c.clientAs(as)
+	if err != nil {
+		return nil, err
+	}
+	return client.CoreV1().LimitRanges(lr.Namespace).Update(
 		context.Background(),
 		lr,
 		metav1.UpdateOptions{},
 	)
 }
 
-func DeleteLimitRanges(lr *corev1.LimitRange) error {
-	return clientset.CoreV1().LimitRanges(lr.Namespace).Delete(
+func (c *Client) DeleteLimitRanges(lr *corev1.LimitRange, as *User) error {
+	client, err := c.clientAs(as)
+	if err != nil {
+		return err
+	}
+	return client.CoreV1().LimitRanges(lr.Namespace).Delete(
 		context.Background(),
 		lr.GetName(),
 		metav1.DeleteOptions{},
 	)
 }
+
+func (c *Client) GetLease(name, ns string) (*coordinationv1.Lease, error) {
+	return c.clientset.CoordinationV1().Leases(ns).Get(
+		context.Background(),
+		name,
+		metav1.GetOptions{},
+	)
+}
+
+func (c *Client) CreateLease(lease *coordinationv1.Lease) (*coordinationv1.Lease, error) {
+	return c.clientset.CoordinationV1().Leases(lease.Namespace).Create(
+		context.Background(),
+		lease,
+		metav1.CreateOptions{},
+	)
+}
+
+func (c *Client) UpdateLease(lease *coordinationv1.Lease) (*coordinationv1.Lease, error) {
+	return c.clientset.CoordinationV1().Leases(lease.Namespace).Update(
+		context.Background(),
+		lease,
+		metav1.UpdateOptions{},
+	)
+}
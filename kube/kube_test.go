@@ -0,0 +1,70 @@
+package kube
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"sync"
+	"testing"
+
+	corev1 "k8s.io/api/core/v1"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/client-go/kubernetes/fake"
+	"k8s.io/client-go/rest"
+)
+
+func TestClientAsImpersonation(t *testing.T) {
+	var mu sync.Mutex
+	users := []string{}
+	groups := [][]string{}
+	tokens := []string{}
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		mu.Lock()
+		users = append(users, r.Header.Get("Impersonate-User"))
+		groups = append(groups, r.Header.Values("Impersonate-Group"))
+		tokens = append(tokens, r.Header.Get("Authorization"))
+		mu.Unlock()
+
+		w.Header().Set("Content-Type", "application/json")
+		w.Write([]byte(`{"apiVersion":"v1","kind":"ResourceQuota","metadata":{"name":"cap-resource","namespace":"team-a"}}`))
+	}))
+	defer server.Close()
+
+	c := NewClient(fake.NewSimpleClientset(), &rest.Config{Host: server.URL, BearerToken: "service-token"})
+	rq := &corev1.ResourceQuota{ObjectMeta: metav1.ObjectMeta{Name: "cap-resource", Namespace: "team-a"}}
+
+	alice := &User{Name: "alice", Groups: []string{"dev", "ops"}}
+	for _, as := range []*User{alice, {Name: "alice", Groups: []string{"ops", "dev"}}, {Name: "alice", Groups: []string{"dev,ops"}}} {
+		if _, err := c.UpdateQuota(rq, as); err != nil {
+			t.Fatalf("UpdateQuota as %v: %s", as, err)
+		}
+	}
+
+	if len(c.impersonated) != 2 {
+		t.Errorf("cached clients = %d, want 2", len(c.impersonated))
+	}
+	first, err := c.clientAs(alice)
+	if err != nil {
+		t.Fatal(err)
+	}
+	second, err := c.clientAs(&User{Name: "alice", Groups: []string{"ops", "dev"}})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if first != second {
+		t.Error("client for the same user is not reused")
+	}
+
+	mu.Lock()
+	defer mu.Unlock()
+	for i, user := range users {
+		if user != "alice" {
+			t.Errorf("request %d: Impersonate-User = %q, want alice", i, user)
+		}
+		if tokens[i] != "Bearer service-token" {
+			t.Errorf("request %d: Authorization = %q, want the service token", i, tokens[i])
+		}
+	}
+	if len(groups) != 3 || len(groups[0]) != 2 || len(groups[2]) != 1 || groups[2][0] != "dev,ops" {
+		t.Errorf("Impersonate-Group = %q", groups)
+	}
+}
@@ -0,0 +1,91 @@
+package metrics
+
+import (
+	"net/http"
+
+	"github.com/prometheus/client_golang/prometheus"
+	"github.com/prometheus/client_golang/prometheus/promauto"
+	"github.com/prometheus/client_golang/prometheus/promhttp"
+)
+
+// префикс метрик сервиса
+const namespace = "resource_manager"
+
+var (
+	// HTTPRequests запросы к API сервиса
+	HTTPRequests = promauto.NewCounterVec(prometheus.CounterOpts{
+		Namespace: namespace,
+		Name:      "http_requests_total",
+		Help:      "HTTP requests by route, method and status code.",
+	}, []string{"route", "method", "code"})
+
+	// HTTPRequestDuration время обработки запросов к API сервиса
+	HTTPRequestDuration = promauto.NewHistogramVec(prometheus.HistogramOpts{
+		Namespace: namespace,
+		Name:      "http_request_duration_seconds",
+		Help:      "HTTP request latency by route, method and status code.",
+		Buckets:   prometheus.DefBuckets,
+	}, []string{"route", "method", "code"})
+
+	// AdmissionDecisions решения по запросам квот
+	AdmissionDecisions = promauto.NewCounterVec(prometheus.CounterOpts{
+		Namespace: namespace,
+		Name:      "admission_decisions_total",
+		Help:      "Quota admission decisions by business, operation and outcome.",
+	}, []string{"business", "operation", "outcome"})
+
+	// PrometheusQueryDuration время выполнения запросов в prometheus
+	PrometheusQueryDuration = promauto.NewHistogram(prometheus.HistogramOpts{
+		Namespace: namespace,
+		Name:      "prometheus_query_duration_seconds",
+		Help:      "Latency of queries to Prometheus.",
+		Buckets:   prometheus.DefBuckets,
+	})
+
+	// PrometheusQueryErrors ошибки запросов в prometheus
+	PrometheusQueryErrors = promauto.NewCounter(prometheus.CounterOpts{
+		Namespace: namespace,
+		Name:      "prometheus_query_errors_total",
+		Help:      "Failed queries to Prometheus.",
+	})
+
+	// KubeAPIErrors ошибки запросов в kubernetes API
+	KubeAPIErrors = promauto.NewCounterVec(prometheus.CounterOpts{
+		Namespace: namespace,
+		Name:      "kube_api_errors_total",
+		Help:      "Kubernetes API requests that did not succeed, by method and status code.",
+	}, []string{"method", "code"})
+
+	// BusinessAvailable доступные ресурсы колонны для установки квот
+	BusinessAvailable = promauto.NewGaugeVec(prometheus.GaugeOpts{
+		Namespace: namespace,
+		Name:      "business_available",
+		Help:      "Resources available to the business for new quotas.",
+	}, []string{"business", "resource"})
+
+	// BusinessOvercommit превышение установленных квот колонны над рассчитанными ресурсами
+	BusinessOvercommit = promauto.NewGaugeVec(prometheus.GaugeOpts{
+		Namespace: namespace,
+		Name:      "business_overcommit",
+		Help:      "Amount by which the business quotas exceed its calculated capacity.",
+	}, []string{"business", "resource"})
+
+	// DriftLastRun время последней проверки колонн (unix time)
+	DriftLastRun = promauto.NewGauge(prometheus.GaugeOpts{
+		Namespace: namespace,
+		Name:      "drift_last_run_timestamp_seconds",
+		Help:      "Time of the last drift check.",
+	})
+
+	// DriftErrors ошибки расчета при проверке колонн
+	DriftErrors = promauto.NewCounterVec(prometheus.CounterOpts{
+		Namespace: namespace,
+		Name:      "drift_errors_total",
+		Help:      "Errors while checking the business for drift.",
+	}, []string{"business"})
+)
+
+// Handler обработчик для отдачи метрик сервиса
+func Handler() http.Handler {
+	return promhttp.Handler()
+}
@@ -0,0 +1,66 @@
+package processing
+
+import (
+	"sync"
+	"time"
+
+	log "k8s.io/klog/v2"
+)
+
+// Start запуск фоновых задач; задачи завершаются при закрытии stop
+// возвращаемый канал закрывается после завершения всех задач
+func (s *Service) Start(stop <-chan struct{}) <-chan struct{} {
+	var wg sync.WaitGroup
+	run := func(name string, interval time.Duration, immediate bool, f func()) {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			runPeriodic(name, interval, immediate, f, stop)
+		}()
+	}
+
+	if s.cfg.BurstPool.Enabled {
+		run("burst reclaimer", s.cfg.BurstPool.ReclaimInterval, false, s.reclaimBurst)
+	}
+	run("grant expiry reconciler", s.cfg.GrantExpiry.ReconcileInterval, false, s.reconcileExpiry)
+	// статус колонн и метрики превышения квот доступны сразу после запуска, а не через drift.interval
+	run("drift reconciler", s.cfg.Drift.Interval, true, s.reconcileDrift)
+
+	if s.events != nil {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			s.runEvents(stop)
+		}()
+	}
+
+	done := make(chan struct{})
+	go func() {
+		wg.Wait()
+		close(done)
+	}()
+	return done
+}
+
+// runPeriodic выполнение f с интервалом interval до закрытия stop
+// при immediate f выполняется сразу при запуске; начатое выполнение f не прерывается
+func runPeriodic(name string, interval time.Duration, immediate bool, f func(), stop <-chan struct{}) {
+	log.Infof("Start %s, interval %s", name, interval)
+
+	if immediate {
+		f()
+	}
+
+	ticker := time.NewTicker(interval)
+	defer ticker.Stop()
+
+	for {
+		select {
+		case <-ticker.C:
+			f()
+		case <-stop:
+			log.Infof("Stop %s", name)
+			return
+		}
+	}
+}
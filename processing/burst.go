@@ -0,0 +1,393 @@
+package processing
+
+import (
+	"fmt"
+	"strconv"
+	"time"
+
+	"resource-manager/config"
+	"resource-manager/resourcemath"
+
+	jsoniter "github.com/json-iterator/go"
+
+	corev1 "k8s.io/api/core/v1"
+	"k8s.io/apimachinery/pkg/api/resource"
+
+	log "k8s.io/klog/v2"
+)
+
+const (
+	// BURST_SOURCE_FIXED пул задан в конфигурации
+	BURST_SOURCE_FIXED = "fixed"
+	// BURST_SOURCE_INFRA_FEE пул - процент от infra_fee, удерживаемого с колонн
+	BURST_SOURCE_INFRA_FEE = "infra_fee"
+
+	DEFAULT_BURST_MAX_DURATION     = 24 * time.Hour
+	DEFAULT_BURST_RECLAIM_INTERVAL = time.Minute
+
+	// BURST_BORROWED_ANNOTATION аннотация квоты с ресурсами, взятыми из burst-пула
+	BURST_BORROWED_ANNOTATION = "resource-manager/burst-borrowed"
+	// BURST_EXPIRES_ANNOTATION аннотация квоты со временем возврата ресурсов в пул (RFC3339)
+	BURST_EXPIRES_ANNOTATION = "resource-manager/burst-expires-at"
+
+	// ключ блокировки burst-пула в отдельном от колонн locker
+	burstPoolKey = "burst-pool"
+	// имя объекта Lease burst-пула; не начинается с leaseNamePrefix, поэтому не совпадает с lease колонн
+	burstPoolLeaseName = "resource-manager-burst-pool"
+)
+
+type BurstPoolType struct {
+	Enabled         bool
+	Source          string
+	Pool            corev1.ResourceList
+	Percent         int
+	MaxDuration     time.Duration
+	ReclaimInterval time.Duration
+}
+
+// BurstPoolStatus размер и занятость burst-пула
+type BurstPoolStatus struct {
+	Enabled bool   `json:"enabled"`
+	Source  string `json:"source,omitempty"`
+	// Size размер пула
+	Size corev1.ResourceList `json:"size,omitempty"`
+	// Borrowed ресурсы, взятые из пула квотами в кластере
+	Borrowed corev1.ResourceList `json:"borrowed,omitempty"`
+	// Available свободные ресурсы пула: Size - Borrowed
+	Available corev1.ResourceList `json:"available,omitempty"`
+}
+
+// initBurstPool инициализация настроек burst-пула
+func (s *Service) initBurstPool(c config.BurstPoolType) error {
+	s.cfg.BurstPool = BurstPoolType{Enabled: c.Enabled}
+	if !c.Enabled {
+		return nil
+	}
+
+	s.cfg.BurstPool.Source = c.Source
+	if s.cfg.BurstPool.Source == "" {
+		s.cfg.BurstPool.Source = BURST_SOURCE_FIXED
+	}
+
+	switch s.cfg.BurstPool.Source {
+	case BURST_SOURCE_FIXED:
+		s.cfg.BurstPool.Pool = corev1.ResourceList{}
+		for rname, v := range c.Pool {
+			q, err := resource.ParseQuantity(v)
+			if err != nil {
+				return fmt.Errorf("burst pool: pool %s: %s", rname, err)
+			}
+			s.cfg.BurstPool.Pool[corev1.ResourceName(rname)] = q
+		}
+		if len(s.cfg.BurstPool.Pool) == 0 {
+			return fmt.Errorf("burst pool: pool must be set for source %s", BURST_SOURCE_FIXED)
+		}
+	case BURST_SOURCE_INFRA_FEE:
+		percent, err := strconv.Atoi(c.Percent)
+		if err != nil {
+			return fmt.Errorf("burst pool: percent: %s", err)
+		}
+		if percent <= 0 || percent > 100 {
+			return fmt.Errorf("burst pool: percent must be in range 1..100")
+		}
+		s.cfg.BurstPool.Percent = percent
+		// эта часть infra_fee не достается колоннам из infra_customers
+		s.cfg.Calculate.BurstPoolPercent = percent
+	default:
+		return fmt.Errorf("burst pool: unknown source %q", s.cfg.BurstPool.Source)
+	}
+
+	s.cfg.BurstPool.MaxDuration = DEFAULT_BURST_MAX_DURATION
+	if c.MaxDuration != "" {
+		d, err := time.ParseDuration(c.MaxDuration)
+		if err != nil {
+			return err
+		}
+		s.cfg.BurstPool.MaxDuration = d
+	}
+
+	s.cfg.BurstPool.ReclaimInterval = DEFAULT_BURST_RECLAIM_INTERVAL
+	if c.ReclaimInterval != "" {
+		d, err := time.ParseDuration(c.ReclaimInterval)
+		if err != nil {
+			return err
+		}
+		s.cfg.BurstPool.ReclaimInterval = d
+	}
+
+	return nil
+}
+
+// checkBurst проверка запрошенного срока заема из burst-пула
+func (s *Service) checkBurst(d time.Duration) error {
+	if !s.cfg.BurstPool.Enabled {
+		return fmt.Errorf("%w: burst pool is disabled", ErrBurstNotAllowed)
+	}
+	if d > s.cfg.BurstPool.MaxDuration {
+		return fmt.Errorf("%w: duration %s exceeds max_duration %s", ErrBurstNotAllowed, d, s.cfg.BurstPool.MaxDuration)
+	}
+	return nil
+}
+
+// burstPoolSize размер burst-пула
+func (s *Service) burstPoolSize() (corev1.ResourceList, error) {
+	if s.cfg.BurstPool.Source == BURST_SOURCE_FIXED {
+		return s.cfg.BurstPool.Pool.DeepCopy(), nil
+	}
+
+	// процент от infra_fee с учетом переподписки
+	rl := corev1.ResourceList{}
+	for rname := range s.cfg.Calculate.Resources {
+		value, err := infraShare(s.prom, rname, s.cfg.Calculate)
+		if err != nil {
+			return nil, err
+		}
+		value.Mul(value, feeRatio(s.cfg.BurstPool.Percent))
+
+		oversubscription, err := decFromFloat(s.cfg.Calculate.oversubscription("", rname))
+		if err != nil {
+			return nil, err
+		}
+		value.Mul(value, oversubscription)
+
+		rl[rname], err = decToQuantity(rname, value)
+		if err != nil {
+			return nil, err
+		}
+	}
+	return rl, nil
+}
+
+// GetBurstPoolStatus размер и занятость burst-пула
+func (s *Service) GetBurstPoolStatus() (*BurstPoolStatus, error) {
+	if !s.cfg.BurstPool.Enabled {
+		return &BurstPoolStatus{}, nil
+	}
+
+	size, err := s.burstPoolSize()
+	if err != nil {
+		return nil, err
+	}
+
+	quotas, err := s.quotas.GetAllQuotas()
+	if err != nil {
+		return nil, err
+	}
+
+	borrowed := corev1.ResourceList{}
+	for i := range quotas.Items {
+		borrowed = resourcemath.Add(borrowed, burstBorrowed(&quotas.Items[i]))
+	}
+
+	return &BurstPoolStatus{
+		Enabled:   true,
+		Source:    s.cfg.BurstPool.Source,
+		Size:      size,
+		Borrowed:  borrowed,
+		Available: resourcemath.Sub(size, borrowed),
+	}, nil
+}
+
+// lockBurstPool захват блокировки burst-пула
+// захватывается только под блокировкой колонны, чтобы порядок захвата был одинаковым;
+// блокировка и lease пула не пересекаются с блокировками колонн, в том числе колонны burst-pool
+func (s *Service) lockBurstPool() (func(), error) {
+	return s.lock(&s.burstLocker, burstPoolKey, burstPoolLeaseName)
+}
+
+// borrowBurst заем из burst-пула ресурсов, которых не хватает колонне
+// cause - ошибка проверки ресурсов колонны; возвращается, если пул не может покрыть нехватку
+func (s *Service) borrowBurst(report *AdmissionReport, cause error, d time.Duration) error {
+	borrow := corev1.ResourceList{}
+	for _, short := range Shortfall(cause) {
+		borrow[short.Resource] = short.Missing.DeepCopy()
+	}
+	if len(borrow) == 0 {
+		return cause
+	}
+
+	status, err := s.GetBurstPoolStatus()
+	if err != nil {
+		return err
+	}
+	report.BurstAvailable = status.Available
+
+	available := resourcemath.Filter(status.Available, resourcemath.Keys(borrow))
+	if short := resourcemath.Less(available, borrow); len(short) > 0 {
+		log.Infof("Burst pool: not enough resources for the business %s: %v", report.Business, short)
+		return cause
+	}
+
+	expires := time.Now().Add(d).UTC().Truncate(time.Second)
+	report.Borrow = borrow
+	report.BurstExpiresAt = &expires
+	return nil
+}
+
+// burstBorrowed ресурсы, взятые квотой из burst-пула
+func burstBorrowed(rq *corev1.ResourceQuota) corev1.ResourceList {
+	v, ok := rq.Annotations[BURST_BORROWED_ANNOTATION]
+	if !ok {
+		return corev1.ResourceList{}
+	}
+
+	json := jsoniter.ConfigCompatibleWithStandardLibrary
+	rl := corev1.ResourceList{}
+	if err := json.Unmarshal([]byte(v), &rl); err != nil {
+		log.Errorf("Burst pool: annotation %s on %s/%s: %s", BURST_BORROWED_ANNOTATION, rq.Namespace, rq.Name, err)
+		return corev1.ResourceList{}
+	}
+	return rl
+}
+
+// burstExpiry время возврата ресурсов квоты в burst-пул
+func burstExpiry(rq *corev1.ResourceQuota) (time.Time, bool) {
+	v, ok := rq.Annotations[BURST_EXPIRES_ANNOTATION]
+	if !ok {
+		return time.Time{}, false
+	}
+
+	expires, err := time.Parse(time.RFC3339, v)
+	if err != nil {
+		log.Errorf("Burst pool: annotation %s on %s/%s: %s", BURST_EXPIRES_ANNOTATION, rq.Namespace, rq.Name, err)
+		return time.Time{}, false
+	}
+	return expires, true
+}
+
+// setBurstAnnotations запись заема в аннотации квоты
+// записываются только положительные значения; если их нет, аннотации удаляются
+func setBurstAnnotations(rq *corev1.ResourceQuota, borrowed corev1.ResourceList, expires time.Time) error {
+	positive := corev1.ResourceList{}
+	for rname, q := range borrowed {
+		if q.Sign() > 0 {
+			positive[rname] = q
+		}
+	}
+
+	if len(positive) == 0 {
+		delete(rq.Annotations, BURST_BORROWED_ANNOTATION)
+		delete(rq.Annotations, BURST_EXPIRES_ANNOTATION)
+		return nil
+	}
+
+	json := jsoniter.ConfigCompatibleWithStandardLibrary
+	b, err := json.Marshal(positive)
+	if err != nil {
+		return err
+	}
+
+	if rq.Annotations == nil {
+		rq.Annotations = make(map[string]string)
+	}
+	rq.Annotations[BURST_BORROWED_ANNOTATION] = string(b)
+	rq.Annotations[BURST_EXPIRES_ANNOTATION] = expires.UTC().Format(time.RFC3339)
+	return nil
+}
+
+// applyBurst аннотации заема у запрошенной квоты rq
+// аннотации из запроса не учитываются: сохраняется заем текущей квоты current (nil при создании)
+// и добавляется новый заем из report;
+// если квота уменьшается, заем текущей квоты уменьшается на ту же величину, но не ниже нуля
+func applyBurst(rq, current *corev1.ResourceQuota, report *AdmissionReport) error {
+	delete(rq.Annotations, BURST_BORROWED_ANNOTATION)
+	delete(rq.Annotations, BURST_EXPIRES_ANNOTATION)
+
+	borrowed := corev1.ResourceList{}
+	var expires time.Time
+	if current != nil {
+		borrowed = keptBorrowed(rq.Spec.Hard, current, report.Borrow)
+		expires, _ = burstExpiry(current)
+	}
+
+	if report.BurstExpiresAt != nil {
+		borrowed = resourcemath.Add(borrowed, report.Borrow)
+		if report.BurstExpiresAt.After(expires) {
+			expires = *report.BurstExpiresAt
+		}
+	}
+
+	return setBurstAnnotations(rq, borrowed, expires)
+}
+
+// keptBorrowed часть заема текущей квоты current, оставшаяся в запрошенном hard
+// собственные ресурсы квоты - current hard - borrowed, из hard исключается новый заем borrow;
+// по каждому ресурсу заем равен Max(0, hard - borrow - собственные), но не больше прежнего
+func keptBorrowed(hard corev1.ResourceList, current *corev1.ResourceQuota, borrow corev1.ResourceList) corev1.ResourceList {
+	borrowed := burstBorrowed(current)
+	keys := resourcemath.Keys(borrowed)
+
+	own := resourcemath.Filter(resourcemath.Sub(current.Spec.Hard, borrowed), keys)
+	requested := resourcemath.Filter(resourcemath.Sub(hard, borrow), keys)
+	kept := resourcemath.Max(resourcemath.Zero(borrowed), resourcemath.Sub(requested, own))
+	return resourcemath.Min(kept, borrowed)
+}
+
+// reclaimBurst возврат в burst-пул ресурсов квот с истекшим сроком заема
+func (s *Service) reclaimBurst() {
+	quotas, err := s.quotas.GetAllQuotas()
+	if err != nil {
+		log.Errorf("Burst reclaimer: get resource quotas: %s", err)
+		return
+	}
+
+	now := time.Now()
+	for i := range quotas.Items {
+		rq := &quotas.Items[i]
+		expires, ok := burstExpiry(rq)
+		if !ok || now.Before(expires) {
+			continue
+		}
+		if err := s.reclaimQuota(rq.Namespace, rq.Name); err != nil {
+			log.Errorf("Burst reclaimer: %s/%s: %s", rq.Namespace, rq.Name, err)
+		}
+	}
+}
+
+// reclaimQuota уменьшение квоты на взятые из burst-пула ресурсы
+// квота не уменьшается ниже status.used; невозвращенная часть остается в аннотации
+// и возвращается при следующих проверках
+func (s *Service) reclaimQuota(ns, name string) error {
+	namespace, err := s.namespaces.GetNamespace(ns)
+	if err != nil {
+		return err
+	}
+
+	businessName, err := s.GetBusinessName(namespace)
+	if err != nil {
+		return err
+	}
+
+	unlock, err := s.lockAdmission(businessName)
+	if err != nil {
+		return err
+	}
+	defer unlock()
+
+	rq, err := s.quotas.GetQuota(name, ns)
+	if err != nil {
+		return err
+	}
+
+	hard := rq.Spec.Hard
+	keys := resourcemath.Keys(hard)
+	base := resourcemath.Filter(resourcemath.Sub(hard, burstBorrowed(rq)), keys)
+	newHard := resourcemath.Max(base, resourcemath.Filter(rq.Status.Used, keys))
+	remaining := resourcemath.Sub(newHard, base)
+
+	expires, _ := burstExpiry(rq)
+	if err := setBurstAnnotations(rq, remaining, expires); err != nil {
+		return err
+	}
+
+	delta := resourcemath.Sub(newHard, hard)
+	rq.Spec.Hard = newHard
+
+	if _, err := s.quotas.UpdateQuota(rq, nil); err != nil {
+		return err
+	}
+	s.ledger.add(businessName, rq.Namespace, rq.Name, newHard, delta)
+	s.recordQuotaShrunk(rq, delta, "burst expired")
+	log.Infof("Burst reclaimer: reclaim resource quota: %s; returned: {%s}", infoResourceQuota(rq), infoResourceList(resourcemath.Neg(delta)))
+	return nil
+}
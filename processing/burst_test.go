@@ -0,0 +1,70 @@
+package processing
+
+import (
+	"testing"
+
+	corev1 "k8s.io/api/core/v1"
+	"k8s.io/apimachinery/pkg/api/resource"
+)
+
+// TestKeptBorrowed заем текущей квоты при ее изменении
+func TestKeptBorrowed(t *testing.T) {
+	// текущая квота: 6 cpu, из них 4 cpu взяты из burst-пула
+	current := testQuota("team-a", "6", "1Gi")
+	current.Annotations = map[string]string{BURST_BORROWED_ANNOTATION: `{"limits.cpu":"4"}`}
+
+	tests := []struct {
+		name   string
+		cpu    string
+		borrow string
+		want   string
+	}{
+		{name: "unchanged", cpu: "6", want: "4"},
+		{name: "grown from own resources", cpu: "8", want: "4"},
+		{name: "shrunk", cpu: "5", want: "3"},
+		{name: "shrunk below own resources", cpu: "1", want: "0"},
+		{name: "shrunk with new borrow", cpu: "5", borrow: "1", want: "2"},
+		{name: "grown with new borrow", cpu: "9", borrow: "3", want: "4"},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			hard := testQuota("team-a", tt.cpu, "1Gi").Spec.Hard
+			borrow := corev1.ResourceList{}
+			if tt.borrow != "" {
+				borrow[corev1.ResourceLimitsCPU] = resource.MustParse(tt.borrow)
+			}
+
+			kept := keptBorrowed(hard, current, borrow)
+			if _, ok := kept[corev1.ResourceLimitsMemory]; ok {
+				t.Errorf("kept = %v, want only borrowed resources", kept)
+			}
+			assertQuantity(t, "kept", kept, corev1.ResourceLimitsCPU, tt.want)
+		})
+	}
+}
+
+// TestLockBurstPoolSeparate блокировка burst-пула не совпадает с блокировкой колонны burst-pool
+func TestLockBurstPoolSeparate(t *testing.T) {
+	for _, lockType := range []string{ADMISSION_LOCK_LOCAL, ADMISSION_LOCK_LEASE} {
+		t.Run(lockType, func(t *testing.T) {
+			c := testConfig()
+			c.AdmissionLock.Type = lockType
+			c.AdmissionLock.LeaseNamespace = "resource-manager"
+			c.AdmissionLock.Timeout = "100ms"
+			s, _, _ := newTestService(t, c)
+
+			unlockBusiness, err := s.lockAdmission(burstPoolKey)
+			if err != nil {
+				t.Fatalf("lockAdmission: %s", err)
+			}
+			defer unlockBusiness()
+
+			unlockPool, err := s.lockBurstPool()
+			if err != nil {
+				t.Fatalf("lockBurstPool while business %s is locked: %s", burstPoolKey, err)
+			}
+			unlockPool()
+		})
+	}
+}
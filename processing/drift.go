@@ -0,0 +1,228 @@
+package processing
+
+import (
+	"fmt"
+	"sort"
+	"strings"
+	"sync"
+	"time"
+
+	"resource-manager/config"
+	"resource-manager/metrics"
+	"resource-manager/resourcemath"
+
+	jsoniter "github.com/json-iterator/go"
+
+	corev1 "k8s.io/api/core/v1"
+
+	log "k8s.io/klog/v2"
+)
+
+const (
+	DEFAULT_DRIFT_INTERVAL = 5 * time.Minute
+
+	// OVERCOMMIT_ANNOTATION аннотация неймспейса с превышением квот колонны над рассчитанными ресурсами
+	OVERCOMMIT_ANNOTATION = "resource-manager/overcommit"
+)
+
+type DriftType struct {
+	Interval           time.Duration
+	AnnotateNamespaces bool
+}
+
+// BusinessStatus результат проверки колонны на превышение квот
+type BusinessStatus struct {
+	Business string `json:"business"`
+	Capacity
+	// Overcommit на сколько установленные квоты превышают рассчитанные ресурсы
+	Overcommit corev1.ResourceList `json:"overcommit,omitempty"`
+	// Overcommitted квоты колонны превышают рассчитанные ресурсы
+	Overcommitted bool      `json:"overcommitted"`
+	CheckedAt     time.Time `json:"checkedAt"`
+}
+
+// driftCache результаты последней проверки по колоннам
+type driftCache struct {
+	mu       sync.Mutex
+	statuses map[string]*BusinessStatus
+}
+
+// initDrift инициализация настроек проверки колонн на превышение квот
+func (s *Service) initDrift(c config.DriftType) error {
+	s.cfg.Drift.Interval = DEFAULT_DRIFT_INTERVAL
+	if c.Interval != "" {
+		d, err := time.ParseDuration(c.Interval)
+		if err != nil {
+			return err
+		}
+		s.cfg.Drift.Interval = d
+	}
+
+	s.cfg.Drift.AnnotateNamespaces = c.AnnotateNamespaces
+	return nil
+}
+
+// GetBusinessStatus результат последней проверки колонны;
+// если колонна ещё не проверялась, проверка выполняется сразу
+// для колонн, которых нет в аннотациях неймспейсов, возвращается ErrBusinessNotFound
+func (s *Service) GetBusinessStatus(business string) (*BusinessStatus, error) {
+	business = strings.ToLower(business)
+
+	s.drift.mu.Lock()
+	status, ok := s.drift.statuses[business]
+	s.drift.mu.Unlock()
+	if ok {
+		return status, nil
+	}
+
+	businessNamespaces, err := s.businessNamespaces()
+	if err != nil {
+		return nil, err
+	}
+	if _, ok := businessNamespaces[business]; !ok {
+		return nil, fmt.Errorf("%w: %s", ErrBusinessNotFound, business)
+	}
+
+	return s.checkDrift(business)
+}
+
+// businessNamespaces неймспейсы по колоннам из их аннотаций
+func (s *Service) businessNamespaces() (map[string][]*corev1.Namespace, error) {
+	namespaces, err := s.namespaces.GetNamespaces()
+	if err != nil {
+		return nil, err
+	}
+
+	businessNamespaces := make(map[string][]*corev1.Namespace)
+	for i := range namespaces.Items {
+		name, err := s.GetBusinessName(&namespaces.Items[i])
+		if err != nil {
+			continue
+		}
+		businessNamespaces[name] = append(businessNamespaces[name], &namespaces.Items[i])
+	}
+	return businessNamespaces, nil
+}
+
+// checkDrift расчет превышения квот колонны над рассчитанными ресурсами
+func (s *Service) checkDrift(business string) (*BusinessStatus, error) {
+	capacity, err := s.GetCapacity(business)
+	if err != nil {
+		return nil, err
+	}
+
+	overcommit := corev1.ResourceList{}
+	for rname, q := range capacity.Available {
+		if q.Sign() < 0 {
+			q.Neg()
+			overcommit[rname] = q
+		}
+	}
+
+	status := &BusinessStatus{
+		Business:      strings.ToLower(business),
+		Capacity:      *capacity,
+		Overcommit:    overcommit,
+		Overcommitted: len(overcommit) > 0,
+		CheckedAt:     time.Now(),
+	}
+
+	s.drift.mu.Lock()
+	s.drift.statuses[status.Business] = status
+	s.drift.mu.Unlock()
+
+	return status, nil
+}
+
+// reconcileDrift проверка всех колонн на превышение квот над рассчитанными ресурсами
+// колонны определяются по аннотациям неймспейсов
+func (s *Service) reconcileDrift() {
+	businessNamespaces, err := s.businessNamespaces()
+	if err != nil {
+		log.Errorf("Drift: get namespaces: %s", err)
+		return
+	}
+
+	businesses := make([]string, 0, len(businessNamespaces))
+	for business := range businessNamespaces {
+		businesses = append(businesses, business)
+	}
+	sort.Strings(businesses)
+
+	statuses := make([]*BusinessStatus, 0, len(businesses))
+	for _, business := range businesses {
+		status, err := s.checkDrift(business)
+		if err != nil {
+			log.Errorf("Drift: check the business %s: %s", business, err)
+			metrics.DriftErrors.WithLabelValues(business).Inc()
+			continue
+		}
+		statuses = append(statuses, status)
+
+		if status.Overcommitted {
+			log.Warningf("Drift: resources hard exceed calculated on the business %s: {%s}", business, infoResourceList(status.Overcommit))
+		}
+
+		if s.cfg.Drift.AnnotateNamespaces {
+			s.annotateOvercommit(businessNamespaces[business], status.Overcommit)
+		}
+	}
+
+	// метрики пересоздаются после всех проверок, чтобы не оставались значения по удаленным колоннам
+	// и между сбросом и заполнением не было долгого окна без значений;
+	// метрики выставляются только для колонн из аннотаций неймспейсов,
+	// чтобы произвольные имена из запросов к api не создавали новые ряды
+	metrics.BusinessOvercommit.Reset()
+	metrics.BusinessAvailable.Reset()
+	for _, status := range statuses {
+		for rname, q := range status.Available {
+			metrics.BusinessAvailable.WithLabelValues(status.Business, string(rname)).Set(q.AsApproximateFloat64())
+		}
+		for rname, q := range status.Overcommit {
+			metrics.BusinessOvercommit.WithLabelValues(status.Business, string(rname)).Set(q.AsApproximateFloat64())
+		}
+	}
+
+	// из кэша удаляются колонны, которых больше нет в аннотациях неймспейсов
+	s.drift.mu.Lock()
+	for business := range s.drift.statuses {
+		if _, ok := businessNamespaces[business]; !ok {
+			delete(s.drift.statuses, business)
+		}
+	}
+	s.drift.mu.Unlock()
+
+	metrics.DriftLastRun.SetToCurrentTime()
+}
+
+// annotateOvercommit запись превышения квот колонны в аннотацию её неймспейсов
+// при отсутствии превышения аннотация удаляется
+func (s *Service) annotateOvercommit(namespaces []*corev1.Namespace, overcommit corev1.ResourceList) {
+	value := ""
+	if len(resourcemath.Keys(overcommit)) > 0 {
+		json := jsoniter.ConfigCompatibleWithStandardLibrary
+		b, err := json.Marshal(overcommit)
+		if err != nil {
+			log.Errorf("Drift: marshal overcommit: %s", err)
+			return
+		}
+		value = string(b)
+	}
+
+	for _, ns := range namespaces {
+		current, ok := ns.Annotations[OVERCOMMIT_ANNOTATION]
+		if current == value && (ok || value == "") {
+			continue
+		}
+
+		if value == "" {
+			delete(ns.Annotations, OVERCOMMIT_ANNOTATION)
+		} else {
+			ns.Annotations[OVERCOMMIT_ANNOTATION] = value
+		}
+
+		if _, err := s.namespaces.UpdateNamespace(ns); err != nil {
+			log.Errorf("Drift: annotate namespace %s: %s", ns.Name, err)
+		}
+	}
+}
@@ -0,0 +1,72 @@
+package processing
+
+import (
+	"context"
+	"errors"
+	"testing"
+	"time"
+
+	corev1 "k8s.io/api/core/v1"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+)
+
+func TestGetBusinessStatus(t *testing.T) {
+	s, clientset, prom := newTestService(t, testConfig(), testNamespace("team-a", "biz"))
+	prom.setAsset(t, s, "biz", corev1.ResourceLimitsCPU, 10)
+
+	if _, err := s.GetBusinessStatus("unknown"); !errors.Is(err, ErrBusinessNotFound) {
+		t.Fatalf("GetBusinessStatus(unknown) error = %v, want %s", err, ErrBusinessNotFound)
+	}
+
+	status, err := s.GetBusinessStatus("BIZ")
+	if err != nil {
+		t.Fatalf("GetBusinessStatus: %s", err)
+	}
+	assertQuantity(t, "available", status.Available, corev1.ResourceLimitsCPU, "10")
+
+	// колонна пропадает из аннотаций: после проверки колонн её статус удаляется из кэша
+	if err := clientset.CoreV1().Namespaces().Delete(context.TODO(), "team-a", metav1.DeleteOptions{}); err != nil {
+		t.Fatal(err)
+	}
+	s.reconcileDrift()
+
+	s.drift.mu.Lock()
+	_, cached := s.drift.statuses["biz"]
+	s.drift.mu.Unlock()
+	if cached {
+		t.Error("status of the removed business is still cached")
+	}
+	if _, err := s.GetBusinessStatus("biz"); !errors.Is(err, ErrBusinessNotFound) {
+		t.Errorf("GetBusinessStatus(biz) error = %v, want %s", err, ErrBusinessNotFound)
+	}
+}
+
+// TestStartReconcilesDrift первая проверка колонн выполняется при запуске, не дожидаясь drift.interval
+func TestStartReconcilesDrift(t *testing.T) {
+	c := testConfig()
+	c.Drift.Interval = "1h"
+	s, _, prom := newTestService(t, c, testNamespace("team-a", "biz"))
+	prom.setAsset(t, s, "biz", corev1.ResourceLimitsCPU, 10)
+
+	stop := make(chan struct{})
+	done := s.Start(stop)
+	defer func() {
+		close(stop)
+		<-done
+	}()
+
+	deadline := time.Now().Add(5 * time.Second)
+	for {
+		s.drift.mu.Lock()
+		status, ok := s.drift.statuses["biz"]
+		s.drift.mu.Unlock()
+		if ok {
+			assertQuantity(t, "available", status.Available, corev1.ResourceLimitsCPU, "10")
+			return
+		}
+		if time.Now().After(deadline) {
+			t.Fatal("drift is not reconciled after start")
+		}
+		time.Sleep(10 * time.Millisecond)
+	}
+}
@@ -1,10 +1,124 @@
 package processing
 
-import "errors"
+import (
+	"errors"
+	"fmt"
+	"sort"
+	"strings"
+
+	corev1 "k8s.io/api/core/v1"
+	"k8s.io/apimachinery/pkg/api/resource"
+)
 
 var (
 	// ErrNoResourcesAvailable нет доступных ресурсов
 	ErrNoResourcesAvailable = errors.New("no resources available")
 	// ErrRequestedQuotaIsLessUsed запрошенная квота меньше используемых ресурсов
 	ErrRequestedQuotaIsLessUsed = errors.New("requested quota is less resources used")
+	// ErrAdmissionLockTimeout не удалось дождаться блокировки на проверку ресурсов колонны
+	ErrAdmissionLockTimeout = errors.New("admission lock timeout")
+	// ErrResourcesNotAllowed в квоте запрошены ресурсы, запрещенные политикой
+	ErrResourcesNotAllowed = errors.New("resources are not allowed")
+	// ErrBurstNotAllowed заем из burst-пула выключен или запрошен на недопустимый срок
+	ErrBurstNotAllowed = errors.New("burst is not allowed")
+	// ErrExpiryNotAllowed срок выдачи квоты уже прошел или превышает max_ttl
+	ErrExpiryNotAllowed = errors.New("expiry is not allowed")
+	// ErrBusinessNotFound колонна не указана ни в одной аннотации неймспейса
+	ErrBusinessNotFound = errors.New("business not found")
+)
+
+// причины ошибок для ответов API
+const (
+	REASON_NO_RESOURCES_AVAILABLE       = "NoResourcesAvailable"
+	REASON_REQUESTED_QUOTA_IS_LESS_USED = "RequestedQuotaIsLessUsed"
+	REASON_ADMISSION_LOCK_TIMEOUT       = "AdmissionLockTimeout"
+	REASON_RESOURCES_NOT_ALLOWED        = "ResourcesNotAllowed"
+	REASON_BURST_NOT_ALLOWED            = "BurstNotAllowed"
+	REASON_EXPIRY_NOT_ALLOWED           = "ExpiryNotAllowed"
+	REASON_BUSINESS_NOT_FOUND           = "BusinessNotFound"
 )
+
+// ResourceShortfall нехватка ресурса для выдачи квоты
+type ResourceShortfall struct {
+	Resource  corev1.ResourceName `json:"resource"`
+	Requested resource.Quantity   `json:"requested"`
+	Available resource.Quantity   `json:"available"`
+	Missing   resource.Quantity   `json:"missing"`
+}
+
+// NoResourcesAvailableError нехватка ресурсов с данными по каждому ресурсу
+// errors.Is(err, ErrNoResourcesAvailable) для этой ошибки возвращает true
+type NoResourcesAvailableError struct {
+	Shortfall []ResourceShortfall
+}
+
+func (e *NoResourcesAvailableError) Error() string {
+	short := []string{}
+	for _, s := range e.Shortfall {
+		short = append(short, fmt.Sprintf("%s short by %s", s.Resource, s.Missing.String()))
+	}
+	return fmt.Sprintf("%s: %s", ErrNoResourcesAvailable, strings.Join(short, ", "))
+}
+
+func (e *NoResourcesAvailableError) Is(target error) bool {
+	return target == ErrNoResourcesAvailable
+}
+
+// checkResources проверка, что запрошенных ресурсов requested достаточно в available
+// проверяются ресурсы из available;
+// возвращает *NoResourcesAvailableError с нехваткой по каждому ресурсу
+func checkResources(available, requested corev1.ResourceList) error {
+	result := []ResourceShortfall{}
+	for rname, avail := range available {
+		req := requested[rname]
+		if avail.Cmp(req) >= 0 {
+			continue
+		}
+		missing := req.DeepCopy()
+		missing.Sub(avail)
+		result = append(result, ResourceShortfall{
+			Resource:  rname,
+			Requested: req.DeepCopy(),
+			Available: avail.DeepCopy(),
+			Missing:   missing,
+		})
+	}
+
+	if len(result) == 0 {
+		return nil
+	}
+
+	sort.Slice(result, func(i, j int) bool { return result[i].Resource < result[j].Resource })
+	return &NoResourcesAvailableError{Shortfall: result}
+}
+
+// Shortfall нехватка ресурсов из ошибки; nil, если ошибка не о нехватке ресурсов
+func Shortfall(err error) []ResourceShortfall {
+	var e *NoResourcesAvailableError
+	if errors.As(err, &e) {
+		return e.Shortfall
+	}
+	return nil
+}
+
+// Reason причина ошибки для ответов API; пустая строка для прочих ошибок
+func Reason(err error) string {
+	switch {
+	case errors.Is(err, ErrNoResourcesAvailable):
+		return REASON_NO_RESOURCES_AVAILABLE
+	case errors.Is(err, ErrRequestedQuotaIsLessUsed):
+		return REASON_REQUESTED_QUOTA_IS_LESS_USED
+	case errors.Is(err, ErrAdmissionLockTimeout):
+		return REASON_ADMISSION_LOCK_TIMEOUT
+	case errors.Is(err, ErrResourcesNotAllowed):
+		return REASON_RESOURCES_NOT_ALLOWED
+	case errors.Is(err, ErrBurstNotAllowed):
+		return REASON_BURST_NOT_ALLOWED
+	case errors.Is(err, ErrExpiryNotAllowed):
+		return REASON_EXPIRY_NOT_ALLOWED
+	case errors.Is(err, ErrBusinessNotFound):
+		return REASON_BUSINESS_NOT_FOUND
+	default:
+		return ""
+	}
+}
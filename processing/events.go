@@ -0,0 +1,146 @@
+package processing
+
+import (
+	"fmt"
+	"strings"
+	"time"
+
+	"resource-manager/kube"
+	"resource-manager/resourcemath"
+
+	corev1 "k8s.io/api/core/v1"
+
+	log "k8s.io/klog/v2"
+)
+
+// DEFAULT_EVENTS_QUEUE размер очереди событий kubernetes
+const DEFAULT_EVENTS_QUEUE = 1000
+
+// queuedEvent событие kubernetes в очереди на запись
+type queuedEvent struct {
+	ref       *corev1.ObjectReference
+	eventType string
+	reason    string
+	message   string
+}
+
+// RecordEvent постановка события kubernetes в очередь
+// события записываются в фоне (см. runEvents), чтобы запросы к kubernetes не выполнялись под блокировкой колонны
+// и не задерживали ответ; через эту же очередь записываются события приемника аудита events;
+// при переполнении очереди событие отбрасывается с ошибкой, без EventRecorder события не записываются
+func (s *Service) RecordEvent(ref *corev1.ObjectReference, eventType, reason, message string) error {
+	if s.events == nil {
+		return nil
+	}
+
+	select {
+	case s.eventQueue <- queuedEvent{ref: ref, eventType: eventType, reason: reason, message: message}:
+		return nil
+	default:
+		return fmt.Errorf("events queue is full, event dropped")
+	}
+}
+
+// recordEvent постановка события kubernetes в очередь; ошибка записывается в лог
+func (s *Service) recordEvent(ref *corev1.ObjectReference, eventType, reason, message string) {
+	if err := s.RecordEvent(ref, eventType, reason, message); err != nil {
+		log.Errorf("Record event %s on %s/%s: %s", reason, ref.Namespace, ref.Name, err)
+	}
+}
+
+// runEvents запись событий из очереди до закрытия stop
+// после закрытия stop записываются события, уже стоящие в очереди
+func (s *Service) runEvents(stop <-chan struct{}) {
+	for {
+		select {
+		case e := <-s.eventQueue:
+			s.writeEvent(e)
+		case <-stop:
+			for {
+				select {
+				case e := <-s.eventQueue:
+					s.writeEvent(e)
+				default:
+					return
+				}
+			}
+		}
+	}
+}
+
+// writeEvent запись события kubernetes; ошибка записывается в лог и не прерывает обработку
+func (s *Service) writeEvent(e queuedEvent) {
+	if err := s.events.RecordEvent(e.ref, e.eventType, e.reason, e.message); err != nil {
+		log.Errorf("Record event %s on %s/%s: %s", e.reason, e.ref.Namespace, e.ref.Name, err)
+	}
+}
+
+// recordQuotaGranted событие о выдаче или изменении квоты rq по решению report
+func (s *Service) recordQuotaGranted(rq *corev1.ResourceQuota, report *AdmissionReport) {
+	message := fmt.Sprintf("Resource quota %s granted: {%s}", rq.Name, infoResourceList(rq.Spec.Hard))
+	if report.Current != nil {
+		message += fmt.Sprintf("; change: {%s}", infoResourceList(report.Delta))
+	}
+	if len(report.Borrow) > 0 {
+		message += fmt.Sprintf("; borrowed from the burst pool: {%s}", infoResourceList(report.Borrow))
+		if report.BurstExpiresAt != nil {
+			message += fmt.Sprintf(" until %s", report.BurstExpiresAt.UTC().Format(time.RFC3339))
+		}
+	}
+	if report.ExpiresAt != nil {
+		message += fmt.Sprintf("; expires at %s", report.ExpiresAt.UTC().Format(time.RFC3339))
+	}
+
+	s.recordEvent(kube.QuotaReference(rq), corev1.EventTypeNormal, kube.EVENT_QUOTA_GRANTED, message)
+}
+
+// recordQuotaRejected событие об отказе в квоте rq с ошибкой err
+// событие записывается на текущую квоту current, а если её нет - на namespace
+func (s *Service) recordQuotaRejected(rq, current *corev1.ResourceQuota, err error) {
+	ref := kube.NamespaceReference(rq.Namespace)
+	if current != nil {
+		ref = kube.QuotaReference(current)
+	}
+
+	message := fmt.Sprintf("Resource quota %s rejected", rq.Name)
+	if reason := Reason(err); reason != "" {
+		message += fmt.Sprintf(" (%s)", reason)
+	}
+
+	shortfall := Shortfall(err)
+	if len(shortfall) == 0 {
+		message += fmt.Sprintf(": %s", err)
+	} else {
+		short := []string{}
+		for _, sf := range shortfall {
+			short = append(short, fmt.Sprintf("%s: requested %s, available %s, missing %s",
+				sf.Resource, sf.Requested.String(), sf.Available.String(), sf.Missing.String()))
+		}
+		message += fmt.Sprintf(": %s", strings.Join(short, "; "))
+	}
+
+	s.recordEvent(ref, corev1.EventTypeWarning, kube.EVENT_QUOTA_REJECTED, message)
+}
+
+// recordQuotaShrunk событие об уменьшении квоты rq сервисом по причине cause
+// delta - изменение hard квоты; если квота не уменьшилась, событие не записывается
+func (s *Service) recordQuotaShrunk(rq *corev1.ResourceQuota, delta corev1.ResourceList, cause string) {
+	shrunk := resourcemath.Negative(delta)
+	if len(shrunk) == 0 {
+		return
+	}
+	returned := resourcemath.Filter(resourcemath.Neg(delta), shrunk)
+
+	message := fmt.Sprintf("Resource quota %s shrunk (%s): returned {%s}, hard {%s}",
+		rq.Name, cause, infoResourceList(returned), infoResourceList(rq.Spec.Hard))
+
+	s.recordEvent(kube.QuotaReference(rq), corev1.EventTypeNormal, kube.EVENT_QUOTA_SHRUNK, message)
+}
+
+// recordQuotaDeleted событие об удалении квоты rq сервисом по причине cause
+// квоты уже нет, поэтому событие записывается на namespace
+func (s *Service) recordQuotaDeleted(rq *corev1.ResourceQuota, cause string) {
+	message := fmt.Sprintf("Resource quota %s deleted (%s): returned {%s}", rq.Name, cause, infoResourceList(rq.Spec.Hard))
+
+	s.recordEvent(kube.NamespaceReference(rq.Namespace), corev1.EventTypeNormal, kube.EVENT_QUOTA_SHRUNK, message)
+}
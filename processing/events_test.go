@@ -0,0 +1,101 @@
+package processing
+
+import (
+	"context"
+	"errors"
+	"testing"
+	"time"
+
+	"resource-manager/kube"
+
+	corev1 "k8s.io/api/core/v1"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/client-go/kubernetes/fake"
+)
+
+// eventReasons reasons событий в namespace ns
+func eventReasons(t *testing.T, clientset *fake.Clientset, ns string) []string {
+	t.Helper()
+
+	events, err := clientset.CoreV1().Events(ns).List(context.TODO(), metav1.ListOptions{})
+	if err != nil {
+		t.Fatal(err)
+	}
+	reasons := []string{}
+	for _, e := range events.Items {
+		reasons = append(reasons, e.Reason)
+	}
+	return reasons
+}
+
+// flushEvents запись событий из очереди
+func flushEvents(s *Service) {
+	stop := make(chan struct{})
+	close(stop)
+	s.runEvents(stop)
+}
+
+func TestQuotaEvents(t *testing.T) {
+	tests := []struct {
+		name string
+		opts AdmissionOptions
+		cpu  string
+		err  error
+		want string
+	}{
+		{name: "granted", cpu: "4", want: kube.EVENT_QUOTA_GRANTED},
+		{name: "no resources", cpu: "20", err: ErrNoResourcesAvailable, want: kube.EVENT_QUOTA_REJECTED},
+		{name: "burst not allowed", cpu: "4", opts: AdmissionOptions{Burst: time.Hour}, err: ErrBurstNotAllowed, want: kube.EVENT_QUOTA_REJECTED},
+		{name: "expiry not allowed", cpu: "4", opts: AdmissionOptions{ExpiresAt: time.Now().Add(-time.Hour)}, err: ErrExpiryNotAllowed, want: kube.EVENT_QUOTA_REJECTED},
+		{name: "dry run", cpu: "4", opts: AdmissionOptions{DryRun: true, Burst: time.Hour}, err: ErrBurstNotAllowed},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			s, clientset := newBusiness(t)
+
+			_, _, err := s.CreateResourceQuota(testQuota("team-a", tt.cpu, "1Gi"), tt.opts)
+			if !errors.Is(err, tt.err) {
+				t.Fatalf("error = %v, want %v", err, tt.err)
+			}
+
+			// события записываются в фоне, а не при обработке запроса
+			if reasons := eventReasons(t, clientset, "team-a"); len(reasons) != 0 {
+				t.Fatalf("events %v recorded before the queue is flushed", reasons)
+			}
+
+			flushEvents(s)
+			reasons := eventReasons(t, clientset, "team-a")
+			if tt.want == "" {
+				if len(reasons) != 0 {
+					t.Errorf("events = %v, want none", reasons)
+				}
+				return
+			}
+			if len(reasons) != 1 || reasons[0] != tt.want {
+				t.Errorf("events = %v, want [%s]", reasons, tt.want)
+			}
+		})
+	}
+}
+
+func TestRecordEventQueueFull(t *testing.T) {
+	s, clientset := newBusiness(t)
+
+	for i := 0; i < DEFAULT_EVENTS_QUEUE; i++ {
+		if err := s.RecordEvent(kube.NamespaceReference("team-a"), corev1.EventTypeNormal, kube.EVENT_QUOTA_GRANTED, "granted"); err != nil {
+			t.Fatalf("event %d: %s", i, err)
+		}
+	}
+	for i := 0; i < 10; i++ {
+		s.recordEvent(kube.NamespaceReference("team-a"), corev1.EventTypeNormal, kube.EVENT_QUOTA_GRANTED, "granted")
+	}
+	if err := s.RecordEvent(kube.NamespaceReference("team-a"), corev1.EventTypeNormal, kube.EVENT_QUOTA_GRANTED, "granted"); err == nil {
+		t.Error("RecordEvent to a full queue succeeded")
+	}
+	flushEvents(s)
+
+	if n := len(eventReasons(t, clientset, "team-a")); n != DEFAULT_EVENTS_QUEUE {
+		t.Errorf("recorded %d events, want %d", n, DEFAULT_EVENTS_QUEUE)
+	}
+}
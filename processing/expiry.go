@@ -0,0 +1,263 @@
+package processing
+
+import (
+	"fmt"
+	"time"
+
+	"resource-manager/config"
+	"resource-manager/resourcemath"
+
+	jsoniter "github.com/json-iterator/go"
+
+	corev1 "k8s.io/api/core/v1"
+	"k8s.io/apimachinery/pkg/api/resource"
+
+	log "k8s.io/klog/v2"
+)
+
+const (
+	DEFAULT_GRANT_RECONCILE_INTERVAL = time.Minute
+
+	// EXPIRES_ANNOTATION аннотация квоты со временем окончания выдачи (RFC3339)
+	EXPIRES_ANNOTATION = "resource-manager/expires-at"
+	// REVERT_ANNOTATION аннотация квоты со значениями hard увеличенных на срок ресурсов,
+	// к которым квота возвращается по окончании выдачи; если аннотации нет, квота удаляется
+	REVERT_ANNOTATION = "resource-manager/revert-to"
+)
+
+type GrantExpiryType struct {
+	// MaxTTL максимальный срок выдачи квоты; 0 - без ограничения
+	MaxTTL            time.Duration
+	ReconcileInterval time.Duration
+}
+
+// initGrantExpiry инициализация настроек выдачи квот на срок
+func (s *Service) initGrantExpiry(c config.GrantExpiryType) error {
+	s.cfg.GrantExpiry.MaxTTL = 0
+	if c.MaxTTL != "" {
+		d, err := time.ParseDuration(c.MaxTTL)
+		if err != nil {
+			return err
+		}
+		s.cfg.GrantExpiry.MaxTTL = d
+	}
+
+	s.cfg.GrantExpiry.ReconcileInterval = DEFAULT_GRANT_RECONCILE_INTERVAL
+	if c.ReconcileInterval != "" {
+		d, err := time.ParseDuration(c.ReconcileInterval)
+		if err != nil {
+			return err
+		}
+		s.cfg.GrantExpiry.ReconcileInterval = d
+	}
+
+	return nil
+}
+
+// checkExpiry проверка запрошенного времени окончания выдачи квоты
+func (s *Service) checkExpiry(expiresAt time.Time) error {
+	if expiresAt.IsZero() {
+		return nil
+	}
+
+	now := time.Now()
+	if !expiresAt.After(now) {
+		return fmt.Errorf("%w: %s has already passed", ErrExpiryNotAllowed, expiresAt.Format(time.RFC3339))
+	}
+	if s.cfg.GrantExpiry.MaxTTL > 0 && expiresAt.Sub(now) > s.cfg.GrantExpiry.MaxTTL {
+		return fmt.Errorf("%w: ttl exceeds max_ttl %s", ErrExpiryNotAllowed, s.cfg.GrantExpiry.MaxTTL)
+	}
+	return nil
+}
+
+// grantExpiry время окончания выдачи квоты
+func grantExpiry(rq *corev1.ResourceQuota) (time.Time, bool) {
+	v, ok := rq.Annotations[EXPIRES_ANNOTATION]
+	if !ok {
+		return time.Time{}, false
+	}
+
+	expiresAt, err := time.Parse(time.RFC3339, v)
+	if err != nil {
+		log.Errorf("Grant expiry: annotation %s on %s/%s: %s", EXPIRES_ANNOTATION, rq.Namespace, rq.Name, err)
+		return time.Time{}, false
+	}
+	return expiresAt, true
+}
+
+// applyExpiry аннотации срока выдачи у запрошенной квоты rq
+// аннотации из запроса не учитываются: сохраняется срок текущей квоты current (nil при создании);
+// при новом сроке expiresAt постоянная квота запоминает текущее значение hard увеличенных ресурсов
+// для возврата к нему; уменьшенные на срок ресурсы не возвращаются
+func applyExpiry(rq, current *corev1.ResourceQuota, expiresAt time.Time) error {
+	delete(rq.Annotations, EXPIRES_ANNOTATION)
+	delete(rq.Annotations, REVERT_ANNOTATION)
+
+	if rq.Annotations == nil {
+		rq.Annotations = make(map[string]string)
+	}
+
+	temporary := false
+	if current != nil {
+		for _, name := range []string{EXPIRES_ANNOTATION, REVERT_ANNOTATION} {
+			if v, ok := current.Annotations[name]; ok {
+				rq.Annotations[name] = v
+			}
+		}
+		_, temporary = current.Annotations[EXPIRES_ANNOTATION]
+	}
+
+	if expiresAt.IsZero() {
+		return nil
+	}
+	rq.Annotations[EXPIRES_ANNOTATION] = expiresAt.UTC().Format(time.RFC3339)
+
+	// квота, выданная на срок при создании, по окончании срока удаляется,
+	// выданная на срок постоянная квота возвращается к текущему значению
+	if current != nil && !temporary {
+		json := jsoniter.ConfigCompatibleWithStandardLibrary
+		b, err := json.Marshal(revertTarget(rq.Spec.Hard, current.Spec.Hard))
+		if err != nil {
+			return err
+		}
+		rq.Annotations[REVERT_ANNOTATION] = string(b)
+	}
+
+	return nil
+}
+
+// revertTarget значения current для ресурсов, которые hard увеличивает;
+// ресурс, которого нет в current, возвращается к нулю
+func revertTarget(hard, current corev1.ResourceList) corev1.ResourceList {
+	target := corev1.ResourceList{}
+	for rname, q := range hard {
+		prev, ok := current[rname]
+		if !ok {
+			prev = resource.Quantity{}
+		}
+		if q.Cmp(prev) > 0 {
+			target[rname] = prev.DeepCopy()
+		}
+	}
+	return target
+}
+
+// reconcileExpiry возврат или удаление квот с истекшим сроком выдачи
+func (s *Service) reconcileExpiry() {
+	quotas, err := s.quotas.GetAllQuotas()
+	if err != nil {
+		log.Errorf("Grant expiry: get resource quotas: %s", err)
+		return
+	}
+
+	now := time.Now()
+	for i := range quotas.Items {
+		rq := &quotas.Items[i]
+		expiresAt, ok := grantExpiry(rq)
+		if !ok || now.Before(expiresAt) {
+			continue
+		}
+		if err := s.expireQuota(rq.Namespace, rq.Name); err != nil {
+			log.Errorf("Grant expiry: %s/%s: %s", rq.Namespace, rq.Name, err)
+		}
+	}
+}
+
+// expireQuota окончание выдачи квоты: возврат к значению из REVERT_ANNOTATION или удаление
+func (s *Service) expireQuota(ns, name string) error {
+	namespace, err := s.namespaces.GetNamespace(ns)
+	if err != nil {
+		return err
+	}
+
+	businessName, err := s.GetBusinessName(namespace)
+	if err != nil {
+		return err
+	}
+
+	unlock, err := s.lockAdmission(businessName)
+	if err != nil {
+		return err
+	}
+	defer unlock()
+
+	// квота могла измениться до захвата блокировки
+	rq, err := s.quotas.GetQuota(name, ns)
+	if err != nil {
+		return err
+	}
+	expiresAt, ok := grantExpiry(rq)
+	if !ok || time.Now().Before(expiresAt) {
+		return nil
+	}
+
+	v, ok := rq.Annotations[REVERT_ANNOTATION]
+	if !ok {
+		log.Infof("Grant expiry: delete resource quota: %s", infoResourceQuota(rq))
+		if err := s.deleteQuota(businessName, rq, nil); err != nil {
+			return err
+		}
+		s.recordQuotaDeleted(rq, "grant expired")
+		return nil
+	}
+
+	json := jsoniter.ConfigCompatibleWithStandardLibrary
+	target := corev1.ResourceList{}
+	if err := json.Unmarshal([]byte(v), &target); err != nil {
+		return fmt.Errorf("annotation %s: %s", REVERT_ANNOTATION, err)
+	}
+
+	return s.revertQuota(businessName, rq, target)
+}
+
+// revertQuota возврат квоты к значению target
+// возврат только уменьшает hard: по каждому ресурсу target hard равен Min(hard, target),
+// но не ниже status.used, поэтому квота не превышает рассчитанные ресурсы колонны
+// и не требует проверки свободных ресурсов;
+// если used не позволяет вернуть квоту полностью, аннотации срока остаются
+// и возврат повторяется при следующих проверках
+func (s *Service) revertQuota(businessName string, rq *corev1.ResourceQuota, target corev1.ResourceList) error {
+	hard := rq.Spec.Hard
+	newHard := hard.DeepCopy()
+	for rname, q := range target {
+		current, ok := hard[rname]
+		if !ok {
+			continue
+		}
+		if q.Cmp(current) < 0 {
+			current = q.DeepCopy()
+		}
+		if used, ok := rq.Status.Used[rname]; ok && used.Cmp(current) > 0 {
+			current = used.DeepCopy()
+		}
+		newHard[rname] = current
+	}
+
+	if len(resourcemath.Negative(resourcemath.Sub(target, resourcemath.Filter(newHard, resourcemath.Keys(target))))) == 0 {
+		delete(rq.Annotations, EXPIRES_ANNOTATION)
+		delete(rq.Annotations, REVERT_ANNOTATION)
+	} else {
+		log.Warningf("Grant expiry: resource quota %s/%s is used above the revert value", rq.Namespace, rq.Name)
+	}
+
+	// возвращенные ресурсы в первую очередь уменьшают заем из burst-пула,
+	// чтобы burst reclaimer не уменьшил квоту ещё раз
+	returned := resourcemath.Max(resourcemath.Sub(hard, newHard), resourcemath.Zero(hard))
+	borrowed := burstBorrowed(rq)
+	burstExpires, _ := burstExpiry(rq)
+	borrowed = resourcemath.Sub(borrowed, resourcemath.Filter(resourcemath.Min(borrowed, returned), resourcemath.Keys(borrowed)))
+	if err := setBurstAnnotations(rq, borrowed, burstExpires); err != nil {
+		return err
+	}
+
+	delta := resourcemath.Sub(newHard, hard)
+	rq.Spec.Hard = newHard
+
+	if _, err := s.quotas.UpdateQuota(rq, nil); err != nil {
+		return err
+	}
+	s.ledger.add(businessName, rq.Namespace, rq.Name, newHard, delta)
+	s.recordQuotaShrunk(rq, delta, "grant expired")
+	log.Infof("Grant expiry: revert resource quota: %s", infoResourceQuota(rq))
+	return nil
+}
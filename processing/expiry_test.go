@@ -0,0 +1,91 @@
+package processing
+
+import (
+	"testing"
+	"time"
+
+	corev1 "k8s.io/api/core/v1"
+	"k8s.io/apimachinery/pkg/api/resource"
+)
+
+func TestRevertTarget(t *testing.T) {
+	current := testQuota("team-a", "4", "16Gi").Spec.Hard
+	hard := testQuota("team-a", "6", "8Gi").Spec.Hard
+	hard[corev1.ResourcePods] = resource.MustParse("10")
+
+	target := revertTarget(hard, current)
+	if len(target) != 2 {
+		t.Fatalf("target = %v, want only increased resources", target)
+	}
+	assertQuantity(t, "target", target, corev1.ResourceLimitsCPU, "4")
+	assertQuantity(t, "target", target, corev1.ResourcePods, "0")
+}
+
+// TestExpireTemporaryShrink временное уменьшение квоты не возвращается по окончании срока,
+// если освободившиеся ресурсы выданы другому неймспейсу колонны
+func TestExpireTemporaryShrink(t *testing.T) {
+	s, _ := newBusiness(t, testQuota("team-a", "6", "16Gi"), testQuota("team-b", "4", "8Gi"))
+
+	opts := AdmissionOptions{ExpiresAt: time.Now().Add(time.Hour)}
+	if _, _, err := s.UpdateResourceQuota(testQuota("team-a", "2", "16Gi"), opts); err != nil {
+		t.Fatalf("temporary shrink: %s", err)
+	}
+	if _, _, err := s.UpdateResourceQuota(testQuota("team-b", "8", "8Gi"), AdmissionOptions{}); err != nil {
+		t.Fatalf("grant to team-b: %s", err)
+	}
+
+	// срок выдачи истек
+	rq := getQuota(t, s, "team-a")
+	rq.Annotations[EXPIRES_ANNOTATION] = time.Now().Add(-time.Minute).UTC().Format(time.RFC3339)
+	if _, err := s.quotas.UpdateQuota(rq, nil); err != nil {
+		t.Fatal(err)
+	}
+	s.reconcileExpiry()
+
+	rq = getQuota(t, s, "team-a")
+	assertQuantity(t, "team-a hard", rq.Spec.Hard, corev1.ResourceLimitsCPU, "2")
+	if _, ok := rq.Annotations[EXPIRES_ANNOTATION]; ok {
+		t.Errorf("expiry annotation is kept after revert")
+	}
+
+	hard, err := s.GetResourcesHard("biz")
+	if err != nil {
+		t.Fatal(err)
+	}
+	assertQuantity(t, "business hard", hard, corev1.ResourceLimitsCPU, "10")
+}
+
+// TestRevertQuotaOnlyLowers возврат не увеличивает квоту выше текущего значения
+// и не опускает её ниже used
+func TestRevertQuotaOnlyLowers(t *testing.T) {
+	tests := []struct {
+		name   string
+		target string
+		used   string
+		want   string
+	}{
+		{name: "lowered", target: "4", want: "4"},
+		{name: "above current", target: "8", want: "6"},
+		{name: "used above target", target: "4", used: "5", want: "5"},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			rq := testQuota("team-a", "6", "16Gi")
+			rq.Annotations = map[string]string{EXPIRES_ANNOTATION: time.Now().UTC().Format(time.RFC3339)}
+			if tt.used != "" {
+				rq.Status.Used = corev1.ResourceList{corev1.ResourceLimitsCPU: resource.MustParse(tt.used)}
+			}
+			s, _ := newBusiness(t, rq.DeepCopy())
+
+			target := corev1.ResourceList{corev1.ResourceLimitsCPU: resource.MustParse(tt.target)}
+			if err := s.revertQuota("biz", rq, target); err != nil {
+				t.Fatal(err)
+			}
+
+			got := getQuota(t, s, "team-a")
+			assertQuantity(t, "hard", got.Spec.Hard, corev1.ResourceLimitsCPU, tt.want)
+			assertQuantity(t, "hard", got.Spec.Hard, corev1.ResourceLimitsMemory, "16Gi")
+		})
+	}
+}
@@ -0,0 +1,164 @@
+package processing
+
+import (
+	"fmt"
+	"strings"
+
+	"resource-manager/resourcemath"
+
+	corev1 "k8s.io/api/core/v1"
+
+	log "k8s.io/klog/v2"
+)
+
+const (
+	HARD_SOURCE_PROMETHEUS = "prometheus"
+	HARD_SOURCE_KUBE       = "kube"
+)
+
+// HardSource источник установленных квот на ресурсы у колонны
+type HardSource interface {
+	GetResourcesHard(business string) (corev1.ResourceList, error)
+}
+
+// promHardSource квоты по метрикам cap_quote_hard_*
+type promHardSource struct {
+	*Service
+}
+
+// kubeHardSource квоты по объектам ResourceQuota в кластере
+type kubeHardSource struct {
+	*Service
+}
+
+// newHardSources источники квот сервиса s по имени
+func newHardSources(s *Service) map[string]HardSource {
+	return map[string]HardSource{
+		HARD_SOURCE_PROMETHEUS: promHardSource{s},
+		HARD_SOURCE_KUBE:       kubeHardSource{s},
+	}
+}
+
+// initHardSource выбор источника квот по конфигурации
+func (s *Service) initHardSource(name string, crossCheck bool) error {
+	if name == "" {
+		name = HARD_SOURCE_PROMETHEUS
+	}
+
+	if _, ok := s.hardSources[name]; !ok {
+		return fmt.Errorf("unknown hard source %q", name)
+	}
+
+	s.cfg.HardSource = name
+	s.cfg.HardSourceCrossCheck = crossCheck
+	return nil
+}
+
+// GetResourcesHard получение установленных квот у колонны из метрик prometheus
+// к значениям из prometheus добавляются квоты, выданные сервисом и ещё не отраженные в метриках
+func (s promHardSource) GetResourcesHard(business string) (corev1.ResourceList, error) {
+	queries, err := s.hardQueries(businessSelector(business))
+	if err != nil {
+		return nil, err
+	}
+
+	rl, err := s.getResourceFromProm(queries)
+	if err != nil {
+		return nil, err
+	}
+
+	return s.applyLedger(business, rl), nil
+}
+
+// GetResourcesHard получение установленных квот у колонны
+// суммированием spec.hard всех ResourceQuota в неймспейсах колонны
+func (s kubeHardSource) GetResourcesHard(business string) (corev1.ResourceList, error) {
+	quotas, err := s.businessQuotas(business)
+	if err != nil {
+		return nil, err
+	}
+
+	rl := corev1.ResourceList{}
+	for _, rq := range quotas {
+		rl = resourcemath.Add(rl, rq.Spec.Hard)
+	}
+
+	return rl, nil
+}
+
+// borrowedResources сумма ресурсов, взятых из burst-пула квотами колонн
+func (s *Service) borrowedResources(businesses ...string) (corev1.ResourceList, error) {
+	quotas, err := s.businessQuotas(businesses...)
+	if err != nil {
+		return nil, err
+	}
+
+	rl := corev1.ResourceList{}
+	for i := range quotas {
+		rl = resourcemath.Add(rl, burstBorrowed(&quotas[i]))
+	}
+
+	return rl, nil
+}
+
+// businessQuotas объекты ResourceQuota в неймспейсах колонн
+func (s *Service) businessQuotas(businesses ...string) ([]corev1.ResourceQuota, error) {
+	namespaces, err := s.namespaces.GetNamespaces()
+	if err != nil {
+		return nil, err
+	}
+
+	// неймспейсы колонн
+	businessNamespaces := make(map[string]bool)
+	for i := range namespaces.Items {
+		name, err := s.GetBusinessName(&namespaces.Items[i])
+		if err != nil {
+			continue
+		}
+		for _, business := range businesses {
+			if name == strings.ToLower(business) {
+				businessNamespaces[namespaces.Items[i].Name] = true
+			}
+		}
+	}
+
+	quotas, err := s.quotas.GetAllQuotas()
+	if err != nil {
+		return nil, err
+	}
+
+	result := []corev1.ResourceQuota{}
+	for _, rq := range quotas.Items {
+		if businessNamespaces[rq.Namespace] {
+			result = append(result, rq)
+		}
+	}
+
+	return result, nil
+}
+
+// crossCheckHard сравнение квот колонны из выбранного источника с другим источником
+// расхождения записываются в лог
+func (s *Service) crossCheckHard(business string, rl corev1.ResourceList) {
+	for name, source := range s.hardSources {
+		if name == s.cfg.HardSource {
+			continue
+		}
+
+		other, err := source.GetResourcesHard(business)
+		if err != nil {
+			log.Errorf("Cross check hard source %s: %s", name, err)
+			continue
+		}
+
+		for rname := range s.cfg.Calculate.Resources {
+			q1, q2 := rl[rname], other[rname]
+			if q1.Cmp(q2) != 0 {
+				log.Warningf(
+					"Cross check hard on the business %s: %s: %s = %s, %s = %s",
+					business, rname, s.cfg.HardSource, q1.String(), name, q2.String(),
+				)
+			}
+		}
+	}
+}
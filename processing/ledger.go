@@ -0,0 +1,225 @@
+package processing
+
+import (
+	"errors"
+	"fmt"
+	"math"
+	"strings"
+	"sync"
+	"time"
+
+	"resource-manager/config"
+	"resource-manager/resourcemath"
+
+	corev1 "k8s.io/api/core/v1"
+
+	log "k8s.io/klog/v2"
+)
+
+const (
+	DEFAULT_LEDGER_NAMESPACE_LABEL = "namespace"
+	DEFAULT_LEDGER_QUOTA_LABEL     = "resourcequota"
+	DEFAULT_LEDGER_TTL             = 10 * time.Minute
+
+	// допустимая относительная погрешность при сравнении значений из prometheus
+	ledgerTolerance = 1e-3
+)
+
+type LedgerType struct {
+	NamespaceLabel string
+	QuotaLabel     string
+	TTL            time.Duration
+}
+
+// reservation запись о квоте, выданной сервисом,
+// которая ещё не отражена в метриках cap_quote_hard_*
+type reservation struct {
+	business  string
+	namespace string
+	name      string
+	// hard значение квоты, которое должно появиться в метриках
+	hard corev1.ResourceList
+	// delta разница между hard и значением, известным prometheus
+	delta   corev1.ResourceList
+	expires time.Time
+	// noSeries при последней проверке в метриках не нашлось значений квоты;
+	// изменяется только под блокировкой журнала
+	noSeries bool
+}
+
+// errNoHardSeries в метриках cap_quote_hard_* нет значений квоты с метками журнала
+var errNoHardSeries = errors.New("no hard series for the quota")
+
+// reservationLedger журнал выданных квот
+type reservationLedger struct {
+	mu      sync.Mutex
+	entries map[string]*reservation
+	// ttl время хранения записи
+	ttl time.Duration
+	// метки неймспейса и квоты в метриках cap_quote_hard_*
+	namespaceLabel string
+	quotaLabel     string
+}
+
+// initLedger инициализация настроек журнала выданных квот
+func (s *Service) initLedger(c config.LedgerType) error {
+	s.cfg.Ledger.NamespaceLabel = c.NamespaceLabel
+	if s.cfg.Ledger.NamespaceLabel == "" {
+		s.cfg.Ledger.NamespaceLabel = DEFAULT_LEDGER_NAMESPACE_LABEL
+	}
+
+	s.cfg.Ledger.QuotaLabel = c.QuotaLabel
+	if s.cfg.Ledger.QuotaLabel == "" {
+		s.cfg.Ledger.QuotaLabel = DEFAULT_LEDGER_QUOTA_LABEL
+	}
+
+	s.cfg.Ledger.TTL = DEFAULT_LEDGER_TTL
+	if c.TTL != "" {
+		ttl, err := time.ParseDuration(c.TTL)
+		if err != nil {
+			return err
+		}
+		s.cfg.Ledger.TTL = ttl
+	}
+	s.ledger.ttl = s.cfg.Ledger.TTL
+	s.ledger.namespaceLabel = s.cfg.Ledger.NamespaceLabel
+	s.ledger.quotaLabel = s.cfg.Ledger.QuotaLabel
+
+	return nil
+}
+
+// ledgerKey ключ записи в журнале: <namespace>/<имя квоты>
+func ledgerKey(namespace, name string) string {
+	return namespace + "/" + name
+}
+
+// add добавление выданной квоты в журнал
+// hard - новое значение квоты, delta - на сколько изменилась квота;
+// запись заменяется новой, поля записи после добавления в журнал не изменяются
+func (l *reservationLedger) add(business, namespace, name string, hard, delta corev1.ResourceList) {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+
+	key := ledgerKey(namespace, name)
+	entry := &reservation{
+		business:  business,
+		namespace: namespace,
+		name:      name,
+		hard:      hard.DeepCopy(),
+		delta:     delta.DeepCopy(),
+		expires:   time.Now().Add(l.ttl),
+	}
+
+	// если предыдущая выдача ещё не отражена в метриках, разница накапливается
+	if prev, ok := l.entries[key]; ok {
+		entry.delta = resourcemath.Add(prev.delta, delta)
+	}
+	l.entries[key] = entry
+}
+
+// pending ресурсы колонны, выданные сервисом и ещё не отраженные в метриках
+// записи, которые уже отражены в метриках (по проверке caughtUp) или устарели, удаляются из журнала;
+// caughtUp выполняет запросы в prometheus, поэтому вызывается без блокировки журнала
+func (l *reservationLedger) pending(business string, caughtUp func(*reservation) (bool, error)) corev1.ResourceList {
+	business = strings.ToLower(business)
+	now := time.Now()
+
+	l.mu.Lock()
+	entries := make(map[string]*reservation)
+	for key, entry := range l.entries {
+		if entry.business != business {
+			continue
+		}
+
+		if now.After(entry.expires) {
+			if entry.noSeries {
+				// квота учитывалась дважды всё время ttl: вероятно, метки журнала не совпадают с метками метрик
+				log.Warningf(
+					"Reservation ledger: entry %s expired, hard metrics have no series with labels %s=%q, %s=%q; check ledger.namespace_label and ledger.quota_label",
+					key, l.namespaceLabel, entry.namespace, l.quotaLabel, entry.name,
+				)
+			} else {
+				log.Warningf("Reservation ledger: entry %s expired before metrics caught up", key)
+			}
+			delete(l.entries, key)
+			continue
+		}
+		entries[key] = entry
+	}
+	l.mu.Unlock()
+
+	type result struct {
+		ok  bool
+		err error
+	}
+	results := make(map[string]result, len(entries))
+	rl := corev1.ResourceList{}
+	for key, entry := range entries {
+		ok, err := caughtUp(entry)
+		if err != nil && !errors.Is(err, errNoHardSeries) {
+			// при ошибке запись остается в журнале, учитывается её разница
+			log.Errorf("Reservation ledger: check entry %s: %s", key, err)
+		}
+		results[key] = result{ok: ok, err: err}
+		if !ok {
+			rl = resourcemath.Add(rl, entry.delta)
+		}
+	}
+
+	l.mu.Lock()
+	defer l.mu.Unlock()
+	for key, entry := range entries {
+		// запись могла быть заменена новой выдачей, пока выполнялась проверка
+		if l.entries[key] != entry {
+			continue
+		}
+
+		res := results[key]
+		entry.noSeries = errors.Is(res.err, errNoHardSeries)
+		if res.ok {
+			log.Infof("Reservation ledger: entry %s caught up by metrics", key)
+			delete(l.entries, key)
+		}
+	}
+
+	return rl
+}
+
+// caughtUp отражено ли значение квоты в метриках cap_quote_hard_*
+func (s *Service) caughtUp(r *reservation) (bool, error) {
+	for rname, rule := range s.cfg.Calculate.Resources {
+		q, ok := r.hard[rname]
+		if !ok {
+			continue
+		}
+
+		query, err := executeQuery(rule.HardQuery, s.quotaSelector(r.namespace, r.name))
+		if err != nil {
+			return false, err
+		}
+
+		v, err := s.prom.GetValue(query)
+		if err != nil {
+			return false, err
+		}
+
+		if !equalFloat(v, q.AsApproximateFloat64()) {
+			// prometheus возвращает 0, если значений нет
+			if v == 0 {
+				return false, fmt.Errorf("%w: %s", errNoHardSeries, query)
+			}
+			return false, nil
+		}
+	}
+	return true, nil
+}
+
+// equalFloat равенство значений с погрешностью ledgerTolerance
+func equalFloat(a, b float64) bool {
+	return math.Abs(a-b) <= ledgerTolerance*math.Max(math.Abs(a), math.Abs(b))
+}
+
+// applyLedger добавление к ресурсам колонны выданных, но ещё не отраженных в метриках
+func (s *Service) applyLedger(business string, rl corev1.ResourceList) corev1.ResourceList {
+	return resourcemath.Add(rl, s.ledger.pending(business, s.caughtUp))
+}
@@ -0,0 +1,96 @@
+package processing
+
+import (
+	"errors"
+	"fmt"
+	"testing"
+	"time"
+
+	corev1 "k8s.io/api/core/v1"
+	"k8s.io/apimachinery/pkg/api/resource"
+)
+
+func newTestLedger() *reservationLedger {
+	return &reservationLedger{entries: make(map[string]*reservation), ttl: time.Minute}
+}
+
+func cpuList(v string) corev1.ResourceList {
+	return corev1.ResourceList{corev1.ResourceLimitsCPU: resource.MustParse(v)}
+}
+
+func TestLedgerPending(t *testing.T) {
+	tests := []struct {
+		name      string
+		caughtUp  bool
+		err       error
+		want      string
+		remaining bool
+		noSeries  bool
+	}{
+		{name: "caught up", caughtUp: true, want: "0", remaining: false},
+		{name: "not caught up", want: "2", remaining: true},
+		{name: "query error", err: fmt.Errorf("prometheus is down"), want: "2", remaining: true},
+		{name: "no series", err: errNoHardSeries, want: "2", remaining: true, noSeries: true},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			l := newTestLedger()
+			l.add("biz", "team-a", "cap-resource", cpuList("2"), cpuList("2"))
+
+			rl := l.pending("BIZ", func(*reservation) (bool, error) { return tt.caughtUp, tt.err })
+			if tt.want == "0" {
+				if len(rl) != 0 {
+					t.Errorf("pending = %v, want none", rl)
+				}
+			} else {
+				assertQuantity(t, "pending", rl, corev1.ResourceLimitsCPU, tt.want)
+			}
+
+			entry, ok := l.entries[ledgerKey("team-a", "cap-resource")]
+			if ok != tt.remaining {
+				t.Fatalf("entry remaining = %v, want %v", ok, tt.remaining)
+			}
+			if ok && entry.noSeries != tt.noSeries {
+				t.Errorf("noSeries = %v, want %v", entry.noSeries, tt.noSeries)
+			}
+		})
+	}
+}
+
+// TestLedgerPendingUnlocked проверка записей выполняется без блокировки журнала;
+// запись, замененная во время проверки, не удаляется
+func TestLedgerPendingUnlocked(t *testing.T) {
+	l := newTestLedger()
+	l.add("biz", "team-a", "cap-resource", cpuList("2"), cpuList("2"))
+
+	rl := l.pending("biz", func(r *reservation) (bool, error) {
+		// при удержании блокировки журнала здесь была бы взаимная блокировка
+		l.add("biz", r.namespace, r.name, cpuList("3"), cpuList("1"))
+		return true, nil
+	})
+	if len(rl) != 0 {
+		t.Errorf("pending = %v, want none", rl)
+	}
+
+	entry, ok := l.entries[ledgerKey("team-a", "cap-resource")]
+	if !ok {
+		t.Fatal("entry replaced during the check was deleted")
+	}
+	assertQuantity(t, "hard", entry.hard, corev1.ResourceLimitsCPU, "3")
+	assertQuantity(t, "delta", entry.delta, corev1.ResourceLimitsCPU, "3")
+}
+
+func TestCaughtUpNoSeries(t *testing.T) {
+	s, _, prom := newTestService(t, testConfig())
+
+	r := &reservation{business: "biz", namespace: "team-a", name: "cap-resource", hard: cpuList("2")}
+	if ok, err := s.caughtUp(r); ok || !errors.Is(err, errNoHardSeries) {
+		t.Fatalf("caughtUp = %v, %v; want no series error", ok, err)
+	}
+
+	prom.set(t, s.cfg.Calculate.Resources[corev1.ResourceLimitsCPU], false, s.quotaSelector("team-a", "cap-resource"), 2)
+	if ok, err := s.caughtUp(r); !ok || err != nil {
+		t.Fatalf("caughtUp = %v, %v; want true", ok, err)
+	}
+}
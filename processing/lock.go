@@ -0,0 +1,322 @@
+package processing
+
+import (
+	"fmt"
+	"os"
+	"regexp"
+	"strings"
+	"sync"
+	"time"
+
+	"resource-manager/config"
+
+	coordinationv1 "k8s.io/api/coordination/v1"
+	apierrors "k8s.io/apimachinery/pkg/api/errors"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+
+	log "k8s.io/klog/v2"
+)
+
+const (
+	ADMISSION_LOCK_LOCAL = "local"
+	ADMISSION_LOCK_LEASE = "lease"
+
+	DEFAULT_LEASE_DURATION         = 60 * time.Second
+	DEFAULT_ADMISSION_LOCK_TIMEOUT = 30 * time.Second
+
+	// интервал между попытками захвата lease
+	leaseRetryInterval = 200 * time.Millisecond
+	// префикс имени объекта Lease
+	leaseNamePrefix = "resource-manager-admission-"
+	// общий ключ блокировки для колонн из infra_customers
+	infraAdmissionKey = "infra-customers"
+)
+
+// недопустимые символы в имени объекта kubernetes
+var invalidNameChars = regexp.MustCompile(`[^a-z0-9.-]+`)
+
+type AdmissionLockType struct {
+	Type           string
+	LeaseNamespace string
+	LeaseDuration  time.Duration
+	Timeout        time.Duration
+	Identity       string
+}
+
+// admissionLocker локальные блокировки по ключу колонны
+type admissionLocker struct {
+	mu    sync.Mutex
+	locks map[string]chan struct{}
+}
+
+// initAdmissionLock инициализация настроек блокировки
+func (s *Service) initAdmissionLock(c config.AdmissionLockType) error {
+	s.cfg.AdmissionLock.Type = c.Type
+	if s.cfg.AdmissionLock.Type == "" {
+		s.cfg.AdmissionLock.Type = ADMISSION_LOCK_LOCAL
+	}
+	if s.cfg.AdmissionLock.Type != ADMISSION_LOCK_LOCAL && s.cfg.AdmissionLock.Type != ADMISSION_LOCK_LEASE {
+		return fmt.Errorf("unknown admission lock type %q", s.cfg.AdmissionLock.Type)
+	}
+
+	s.cfg.AdmissionLock.LeaseDuration = DEFAULT_LEASE_DURATION
+	if c.LeaseDuration != "" {
+		d, err := time.ParseDuration(c.LeaseDuration)
+		if err != nil {
+			return err
+		}
+		s.cfg.AdmissionLock.LeaseDuration = d
+	}
+	// длительность lease хранится в секундах
+	if s.cfg.AdmissionLock.LeaseDuration < time.Second {
+		return fmt.Errorf("admission lock: lease_duration must be at least 1s")
+	}
+
+	s.cfg.AdmissionLock.Timeout = DEFAULT_ADMISSION_LOCK_TIMEOUT
+	if c.Timeout != "" {
+		d, err := time.ParseDuration(c.Timeout)
+		if err != nil {
+			return err
+		}
+		s.cfg.AdmissionLock.Timeout = d
+	}
+
+	s.cfg.AdmissionLock.LeaseNamespace = c.LeaseNamespace
+	if s.cfg.AdmissionLock.Type == ADMISSION_LOCK_LEASE && s.cfg.AdmissionLock.LeaseNamespace == "" {
+		return fmt.Errorf("admission lock: lease_namespace is not set")
+	}
+	if s.cfg.AdmissionLock.Type == ADMISSION_LOCK_LEASE && s.leases == nil {
+		return fmt.Errorf("admission lock: lease store is not set")
+	}
+
+	identity, err := os.Hostname()
+	if err != nil {
+		return err
+	}
+	s.cfg.AdmissionLock.Identity = identity
+
+	return nil
+}
+
+// admissionKey ключ блокировки для колонны
+// колонны из infra_customers используют общий пул ресурсов, поэтому и общий ключ
+func (s *Service) admissionKey(business string) string {
+	if stringInSlice(business, s.cfg.Calculate.InfraCustomers) {
+		return infraAdmissionKey
+	}
+	return business
+}
+
+// lockAdmission захват блокировки на проверку и запись квоты для колонны
+// возвращает функцию для освобождения блокировки
+func (s *Service) lockAdmission(business string) (func(), error) {
+	key := s.admissionKey(business)
+	return s.lock(&s.locker, key, leaseName(key))
+}
+
+// lock захват локальной блокировки key в locker и, при admission_lock.type: lease, lease с именем name
+// возвращает функцию для освобождения блокировки
+func (s *Service) lock(locker *admissionLocker, key, name string) (func(), error) {
+	deadline := time.Now().Add(s.cfg.AdmissionLock.Timeout)
+
+	if err := locker.lock(key, deadline); err != nil {
+		return nil, err
+	}
+
+	if s.cfg.AdmissionLock.Type != ADMISSION_LOCK_LEASE {
+		return func() { locker.unlock(key) }, nil
+	}
+
+	if err := s.acquireLease(name, deadline); err != nil {
+		locker.unlock(key)
+		return nil, err
+	}
+
+	// lease продлевается, пока блокировка удерживается,
+	// иначе при долгих запросах в prometheus его может захватить другая реплика
+	stop := make(chan struct{})
+	done := make(chan struct{})
+	go s.keepLease(name, stop, done)
+
+	return func() {
+		close(stop)
+		<-done
+		if err := s.releaseLease(name); err != nil {
+			log.Errorf("Release lease %s: %s", name, err)
+		}
+		locker.unlock(key)
+	}, nil
+}
+
+// lock захват локальной блокировки по ключу до наступления deadline
+func (l *admissionLocker) lock(key string, deadline time.Time) error {
+	l.mu.Lock()
+	ch, ok := l.locks[key]
+	if !ok {
+		ch = make(chan struct{}, 1)
+		l.locks[key] = ch
+	}
+	l.mu.Unlock()
+
+	timer := time.NewTimer(time.Until(deadline))
+	defer timer.Stop()
+
+	select {
+	case ch <- struct{}{}:
+		return nil
+	case <-timer.C:
+		return ErrAdmissionLockTimeout
+	}
+}
+
+// unlock освобождение локальной блокировки по ключу
+func (l *admissionLocker) unlock(key string) {
+	l.mu.Lock()
+	ch := l.locks[key]
+	l.mu.Unlock()
+	<-ch
+}
+
+// leaseName формирование имени объекта Lease по ключу блокировки
+func leaseName(key string) string {
+	name := leaseNamePrefix + strings.Trim(invalidNameChars.ReplaceAllString(key, "-"), "-.")
+	if len(name) > 253 {
+		name = name[:253]
+	}
+	return name
+}
+
+// acquireLease захват lease до наступления deadline
+func (s *Service) acquireLease(name string, deadline time.Time) error {
+	for {
+		ok, err := s.tryAcquireLease(name)
+		if err != nil {
+			return err
+		}
+		if ok {
+			return nil
+		}
+		if time.Now().After(deadline) {
+			return ErrAdmissionLockTimeout
+		}
+		time.Sleep(leaseRetryInterval)
+	}
+}
+
+// tryAcquireLease попытка захвата lease
+// false без ошибки, если lease удерживается другой репликой
+func (s *Service) tryAcquireLease(name string) (bool, error) {
+	now := metav1.NewMicroTime(time.Now())
+	identity := s.cfg.AdmissionLock.Identity
+	duration := int32(s.cfg.AdmissionLock.LeaseDuration.Seconds())
+
+	lease, err := s.leases.GetLease(name, s.cfg.AdmissionLock.LeaseNamespace)
+	if apierrors.IsNotFound(err) {
+		_, err = s.leases.CreateLease(&coordinationv1.Lease{
+			ObjectMeta: metav1.ObjectMeta{
+				Name:      name,
+				Namespace: s.cfg.AdmissionLock.LeaseNamespace,
+			},
+			Spec: coordinationv1.LeaseSpec{
+				HolderIdentity:       &identity,
+				LeaseDurationSeconds: &duration,
+				AcquireTime:          &now,
+				RenewTime:            &now,
+			},
+		})
+		if apierrors.IsAlreadyExists(err) {
+			return false, nil
+		}
+		return err == nil, err
+	}
+	if err != nil {
+		return false, err
+	}
+
+	if leaseHeld(lease, now.Time) {
+		return false, nil
+	}
+
+	lease.Spec.HolderIdentity = &identity
+	lease.Spec.LeaseDurationSeconds = &duration
+	lease.Spec.AcquireTime = &now
+	lease.Spec.RenewTime = &now
+
+	_, err = s.leases.UpdateLease(lease)
+	if apierrors.IsConflict(err) {
+		return false, nil
+	}
+	return err == nil, err
+}
+
+// keepLease продление lease с интервалом в треть lease_duration до закрытия stop
+// done закрывается после выхода
+func (s *Service) keepLease(name string, stop <-chan struct{}, done chan<- struct{}) {
+	defer close(done)
+
+	ticker := time.NewTicker(s.cfg.AdmissionLock.LeaseDuration / 3)
+	defer ticker.Stop()
+
+	for {
+		select {
+		case <-ticker.C:
+			if err := s.renewLease(name); err != nil {
+				log.Errorf("Renew lease %s: %s", name, err)
+			}
+		case <-stop:
+			return
+		}
+	}
+}
+
+// renewLease продление lease, если он удерживается текущей репликой
+func (s *Service) renewLease(name string) error {
+	lease, err := s.leases.GetLease(name, s.cfg.AdmissionLock.LeaseNamespace)
+	if err != nil {
+		return err
+	}
+
+	spec := lease.Spec
+	if spec.HolderIdentity == nil || *spec.HolderIdentity != s.cfg.AdmissionLock.Identity {
+		return fmt.Errorf("lease is held by another replica")
+	}
+
+	now := metav1.NewMicroTime(time.Now())
+	lease.Spec.RenewTime = &now
+	_, err = s.leases.UpdateLease(lease)
+	return err
+}
+
+// leaseHeld удерживается ли lease на момент now
+func leaseHeld(lease *coordinationv1.Lease, now time.Time) bool {
+	spec := lease.Spec
+	if spec.HolderIdentity == nil || *spec.HolderIdentity == "" {
+		return false
+	}
+	if spec.RenewTime == nil || spec.LeaseDurationSeconds == nil {
+		return false
+	}
+	expire := spec.RenewTime.Add(time.Duration(*spec.LeaseDurationSeconds) * time.Second)
+	return now.Before(expire)
+}
+
+// releaseLease освобождение lease, если он удерживается текущей репликой
+func (s *Service) releaseLease(name string) error {
+	lease, err := s.leases.GetLease(name, s.cfg.AdmissionLock.LeaseNamespace)
+	if err != nil {
+		return err
+	}
+
+	spec := lease.Spec
+	if spec.HolderIdentity == nil || *spec.HolderIdentity != s.cfg.AdmissionLock.Identity {
+		return nil
+	}
+
+	empty := ""
+	lease.Spec.HolderIdentity = &empty
+	_, err = s.leases.UpdateLease(lease)
+	if apierrors.IsConflict(err) {
+		return nil
+	}
+	return err
+}
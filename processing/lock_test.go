@@ -0,0 +1,130 @@
+package processing
+
+import (
+	"errors"
+	"fmt"
+	"sync"
+	"testing"
+	"time"
+
+	corev1 "k8s.io/api/core/v1"
+	"k8s.io/apimachinery/pkg/api/resource"
+	"k8s.io/apimachinery/pkg/runtime"
+)
+
+// TestCreateResourceQuotaParallel параллельные запросы квот одной колонны
+// не выдают в сумме больше рассчитанных ресурсов
+func TestCreateResourceQuotaParallel(t *testing.T) {
+	const (
+		namespaces = 24
+		assetCPU   = 10
+	)
+
+	tests := []struct {
+		name       string
+		lockType   string
+		hardSource string
+	}{
+		{name: "local lock, kube hard", lockType: ADMISSION_LOCK_LOCAL, hardSource: HARD_SOURCE_KUBE},
+		// метрики hard в fake prometheus не обновляются: выданные квоты учитываются только журналом
+		{name: "local lock, prometheus hard", lockType: ADMISSION_LOCK_LOCAL, hardSource: HARD_SOURCE_PROMETHEUS},
+		{name: "lease lock, kube hard", lockType: ADMISSION_LOCK_LEASE, hardSource: HARD_SOURCE_KUBE},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			c := testConfig()
+			c.HardSource = tt.hardSource
+			c.AdmissionLock.Type = tt.lockType
+			c.AdmissionLock.LeaseNamespace = "resource-manager"
+
+			objects := []runtime.Object{}
+			for i := 0; i < namespaces; i++ {
+				objects = append(objects, testNamespace(fmt.Sprintf("team-%d", i), "biz"))
+			}
+			s, _, prom := newTestService(t, c, objects...)
+			prom.setAsset(t, s, "biz", corev1.ResourceLimitsCPU, assetCPU)
+			prom.setAsset(t, s, "biz", corev1.ResourceLimitsMemory, 1024)
+
+			var (
+				wg       sync.WaitGroup
+				mu       sync.Mutex
+				granted  int
+				rejected int
+			)
+			for i := 0; i < namespaces; i++ {
+				wg.Add(1)
+				go func(ns string) {
+					defer wg.Done()
+					_, _, err := s.CreateResourceQuota(testQuota(ns, "1", "1"), AdmissionOptions{})
+
+					mu.Lock()
+					defer mu.Unlock()
+					switch {
+					case err == nil:
+						granted++
+					case errors.Is(err, ErrNoResourcesAvailable):
+						rejected++
+					default:
+						t.Errorf("%s: %s", ns, err)
+					}
+				}(fmt.Sprintf("team-%d", i))
+			}
+			wg.Wait()
+
+			if granted != assetCPU || rejected != namespaces-assetCPU {
+				t.Errorf("granted %d, rejected %d; want %d and %d", granted, rejected, assetCPU, namespaces-assetCPU)
+			}
+
+			quotas, err := s.quotas.GetAllQuotas()
+			if err != nil {
+				t.Fatal(err)
+			}
+			total := resource.Quantity{}
+			for _, rq := range quotas.Items {
+				total.Add(rq.Spec.Hard[corev1.ResourceLimitsCPU])
+			}
+			if total.Cmp(*resource.NewQuantity(assetCPU, resource.DecimalSI)) > 0 {
+				t.Errorf("total limits.cpu %s exceeds calculated %d", total.String(), assetCPU)
+			}
+		})
+	}
+}
+
+// TestLeaseRenewedWhileHeld lease не истекает, пока блокировка удерживается дольше lease_duration
+func TestLeaseRenewedWhileHeld(t *testing.T) {
+	c := testConfig()
+	c.AdmissionLock.Type = ADMISSION_LOCK_LEASE
+	c.AdmissionLock.LeaseNamespace = "resource-manager"
+	c.AdmissionLock.LeaseDuration = "1s"
+	s, _, _ := newTestService(t, c)
+
+	unlock, err := s.lockAdmission("biz")
+	if err != nil {
+		t.Fatalf("lockAdmission: %s", err)
+	}
+
+	time.Sleep(1500 * time.Millisecond)
+
+	name := leaseName("biz")
+	lease, err := s.leases.GetLease(name, c.AdmissionLock.LeaseNamespace)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !leaseHeld(lease, time.Now()) {
+		t.Fatal("lease expired while the lock is held")
+	}
+	if ok, err := s.tryAcquireLease(name); ok || err != nil {
+		t.Fatalf("tryAcquireLease = %v, %v; want false while held", ok, err)
+	}
+
+	unlock()
+
+	lease, err = s.leases.GetLease(name, c.AdmissionLock.LeaseNamespace)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if leaseHeld(lease, time.Now()) {
+		t.Error("lease is held after unlock")
+	}
+}
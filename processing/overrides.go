@@ -0,0 +1,191 @@
+package processing
+
+import (
+	"fmt"
+	"sort"
+	"strconv"
+	"strings"
+
+	"resource-manager/config"
+
+	inf "gopkg.in/inf.v0"
+	corev1 "k8s.io/api/core/v1"
+
+	log "k8s.io/klog/v2"
+)
+
+// BusinessCalculateType параметры расчета, переопределенные для колонны
+// nil - значение не переопределено
+type BusinessCalculateType struct {
+	InfraFee            *int
+	CpuOversubscription *float64
+	Resources           map[corev1.ResourceName]ResourceOverride
+	// Policy политика расчета ресурсов, см. CapacityPolicy
+	Policy string
+	// Pool пул ресурсов для политики fixed
+	Pool corev1.ResourceList
+	// Weight вес колонны для политики fair_share
+	Weight float64
+}
+
+// ResourceOverride параметры расчета ресурса, переопределенные для колонны
+type ResourceOverride struct {
+	Oversubscription *float64
+	InfraFeeExempt   *bool
+}
+
+// initBusinessOverrides инициализация параметров расчета по колоннам
+func (s *Service) initBusinessOverrides(c map[string]config.BusinessOverrideType) error {
+	s.cfg.Calculate.Businesses = make(map[string]BusinessCalculateType)
+
+	for business, o := range c {
+		bc := BusinessCalculateType{
+			Resources: make(map[corev1.ResourceName]ResourceOverride),
+		}
+
+		if o.InfraFee != "" {
+			infraFee, err := strconv.Atoi(o.InfraFee)
+			if err != nil {
+				return fmt.Errorf("business %s: infra_fee: %s", business, err)
+			}
+			bc.InfraFee = &infraFee
+		}
+
+		if o.CpuOversubscription != "" {
+			cpuOversubscription, err := strconv.ParseFloat(o.CpuOversubscription, 64)
+			if err != nil {
+				return fmt.Errorf("business %s: cpu_oversubscription: %s", business, err)
+			}
+			bc.CpuOversubscription = &cpuOversubscription
+		}
+
+		for rname, r := range o.Resources {
+			ro := ResourceOverride{InfraFeeExempt: r.InfraFeeExempt}
+			if r.Oversubscription != "" {
+				oversubscription, err := strconv.ParseFloat(r.Oversubscription, 64)
+				if err != nil {
+					return fmt.Errorf("business %s: resource %s: oversubscription: %s", business, rname, err)
+				}
+				ro.Oversubscription = &oversubscription
+			}
+			bc.Resources[corev1.ResourceName(rname)] = ro
+		}
+
+		if err := initCapacityPolicy(business, o, &bc); err != nil {
+			return err
+		}
+
+		// имя колонны из аннотации приводится к нижнему регистру, см. GetBusinessName
+		s.cfg.Calculate.Businesses[strings.ToLower(business)] = bc
+	}
+
+	return nil
+}
+
+// infraFee процент infra_fee для ресурса колонны
+// порядок приоритета:
+//  1. business_overrides.<колонна>.resources.<ресурс>.infra_fee_exempt
+//  2. resources.<ресурс>.infra_fee_exempt
+//  3. business_overrides.<колонна>.infra_fee
+//  4. infra_fee
+func (c CalculateType) infraFee(business string, rname corev1.ResourceName) int {
+	bc, ok := c.Businesses[strings.ToLower(business)]
+
+	if ro, ok := bc.Resources[rname]; ok && ro.InfraFeeExempt != nil {
+		if *ro.InfraFeeExempt {
+			return 0
+		}
+	} else if rule, ok := c.Resources[rname]; ok && rule.InfraFeeExempt {
+		return 0
+	}
+
+	if ok && bc.InfraFee != nil {
+		return *bc.InfraFee
+	}
+	return c.InfraFee
+}
+
+// oversubscription коэффициент переподписки для ресурса колонны
+// порядок приоритета:
+//  1. business_overrides.<колонна>.resources.<ресурс>.oversubscription
+//  2. business_overrides.<колонна>.cpu_oversubscription (для limits.cpu)
+//  3. oversubscription.<ресурс>
+//  4. oversubscription.<requests|limits>.*
+//  5. без переподписки
+//
+// cpu_oversubscription задает oversubscription.limits.cpu, см. initOversubscription
+func (c CalculateType) oversubscription(business string, rname corev1.ResourceName) float64 {
+	bc := c.Businesses[strings.ToLower(business)]
+
+	if ro, ok := bc.Resources[rname]; ok && ro.Oversubscription != nil {
+		return *ro.Oversubscription
+	}
+
+	if rname == corev1.ResourceLimitsCPU && bc.CpuOversubscription != nil {
+		return *bc.CpuOversubscription
+	}
+
+	if ratio, ok := c.Oversubscription[rname]; ok {
+		return ratio
+	}
+
+	// общий коэффициент для группы ресурсов, например requests.*
+	if i := strings.Index(string(rname), "."); i > 0 {
+		if ratio, ok := c.Oversubscription[rname[:i+1]+"*"]; ok {
+			return ratio
+		}
+	}
+
+	return 1
+}
+
+// initOversubscription инициализация коэффициентов переподписки по ресурсам
+// cpu_oversubscription - устаревший синоним oversubscription.limits.cpu;
+// если cpu_oversubscription не задан, значение по умолчанию применяется
+// только при отсутствии oversubscription.limits.cpu и oversubscription.limits.*
+func (s *Service) initOversubscription(c map[string]string) error {
+	s.cfg.Calculate.Oversubscription = make(map[corev1.ResourceName]float64)
+	for rname, v := range c {
+		ratio, err := strconv.ParseFloat(v, 64)
+		if err != nil {
+			return fmt.Errorf("oversubscription %s: %s", rname, err)
+		}
+		s.cfg.Calculate.Oversubscription[corev1.ResourceName(rname)] = ratio
+	}
+
+	if _, ok := s.cfg.Calculate.Oversubscription[corev1.ResourceLimitsCPU]; ok {
+		if s.cfg.Calculate.cpuOversubscriptionSet {
+			log.Warningf(
+				"cpu_oversubscription is ignored: oversubscription.%s is set",
+				corev1.ResourceLimitsCPU,
+			)
+		}
+		return nil
+	}
+
+	// значение cpu_oversubscription по умолчанию не перекрывает oversubscription.limits.*
+	if _, ok := s.cfg.Calculate.Oversubscription["limits.*"]; ok && !s.cfg.Calculate.cpuOversubscriptionSet {
+		return nil
+	}
+
+	s.cfg.Calculate.Oversubscription[corev1.ResourceLimitsCPU] = s.cfg.Calculate.CpuOversubscription
+	return nil
+}
+
+// overriddenBusinesses колонны с переопределенными параметрами, не входящие в infra_customers
+// список отсортирован, чтобы запросы в prometheus не зависели от порядка обхода map
+func (c CalculateType) overriddenBusinesses() []string {
+	businesses := []string{}
+	for business := range c.Businesses {
+		if !stringInSlice(business, c.InfraCustomers) {
+			businesses = append(businesses, business)
+		}
+	}
+	sort.Strings(businesses)
+	return businesses
+}
+
+// feeRatio доля infra_fee: infra_fee / 100
+func feeRatio(infraFee int) *inf.Dec {
+	return inf.NewDec(int64(infraFee), 2)
+}
@@ -0,0 +1,34 @@
+package processing
+
+import (
+	"testing"
+
+	corev1 "k8s.io/api/core/v1"
+)
+
+func TestOversubscriptionLimitsWildcard(t *testing.T) {
+	tests := []struct {
+		name                string
+		cpuOversubscription string
+		oversubscription    map[string]string
+		want                float64
+	}{
+		{name: "default", want: DEFAULT_CPUOVERSUBSCRIPTION},
+		{name: "limits wildcard only", oversubscription: map[string]string{"limits.*": "2"}, want: 2},
+		{name: "cpu_oversubscription over wildcard", cpuOversubscription: "3", oversubscription: map[string]string{"limits.*": "2"}, want: 3},
+		{name: "explicit limits.cpu", cpuOversubscription: "3", oversubscription: map[string]string{"limits.cpu": "4", "limits.*": "2"}, want: 4},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			c := testConfig()
+			c.CpuOversubscription = tt.cpuOversubscription
+			c.Oversubscription = tt.oversubscription
+			s, _, _ := newTestService(t, c)
+
+			if got := s.cfg.Calculate.oversubscription("biz", corev1.ResourceLimitsCPU); got != tt.want {
+				t.Errorf("limits.cpu oversubscription = %v, want %v", got, tt.want)
+			}
+		})
+	}
+}
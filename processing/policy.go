@@ -0,0 +1,259 @@
+package processing
+
+import (
+	"fmt"
+	"sort"
+	"strconv"
+	"strings"
+
+	"resource-manager/config"
+
+	inf "gopkg.in/inf.v0"
+	corev1 "k8s.io/api/core/v1"
+	"k8s.io/apimachinery/pkg/api/resource"
+)
+
+// политики расчета ресурсов колонны
+const (
+	// POLICY_INFRA_FEE закупленные ресурсы с учетом infra_fee (по умолчанию)
+	POLICY_INFRA_FEE = "infra_fee"
+	// POLICY_FIXED фиксированный пул ресурсов колонны
+	POLICY_FIXED = "fixed"
+	// POLICY_FAIR_SHARE доля общего пула колонн с этой политикой пропорционально весу
+	POLICY_FAIR_SHARE = "fair_share"
+)
+
+// CapacityPolicy политика расчета ресурсов, доступных колонне для установки квот
+type CapacityPolicy interface {
+	// Calculate расчет ресурсов колонны business по закупленным ресурсам rl
+	// метрики, нужные для расчета, запрашиваются через prom
+	Calculate(prom MetricsQuerier, rl corev1.ResourceList, business string, calculateCfg CalculateType) (corev1.ResourceList, error)
+}
+
+type infraFeePolicy struct{}
+
+type fixedPoolPolicy struct{}
+
+type fairSharePolicy struct{}
+
+var capacityPolicies = map[string]CapacityPolicy{
+	POLICY_INFRA_FEE:  infraFeePolicy{},
+	POLICY_FIXED:      fixedPoolPolicy{},
+	POLICY_FAIR_SHARE: fairSharePolicy{},
+}
+
+// initCapacityPolicy инициализация политики расчета ресурсов колонны
+func initCapacityPolicy(business string, o config.BusinessOverrideType, bc *BusinessCalculateType) error {
+	bc.Policy = o.CapacityPolicy
+	if bc.Policy == "" {
+		bc.Policy = POLICY_INFRA_FEE
+	}
+	if _, ok := capacityPolicies[bc.Policy]; !ok {
+		return fmt.Errorf("business %s: unknown capacity_policy %q", business, bc.Policy)
+	}
+
+	bc.Pool = corev1.ResourceList{}
+	for rname, v := range o.Pool {
+		q, err := resource.ParseQuantity(v)
+		if err != nil {
+			return fmt.Errorf("business %s: pool %s: %s", business, rname, err)
+		}
+		bc.Pool[corev1.ResourceName(rname)] = q
+	}
+	if bc.Policy == POLICY_FIXED && len(bc.Pool) == 0 {
+		return fmt.Errorf("business %s: pool must be set for capacity_policy %s", business, POLICY_FIXED)
+	}
+
+	bc.Weight = 1
+	if o.Weight != "" {
+		weight, err := strconv.ParseFloat(o.Weight, 64)
+		if err != nil {
+			return fmt.Errorf("business %s: weight: %s", business, err)
+		}
+		if weight <= 0 {
+			return fmt.Errorf("business %s: weight must be positive", business)
+		}
+		bc.Weight = weight
+	}
+
+	return nil
+}
+
+// policy политика расчета ресурсов колонны
+func (c CalculateType) policy(business string) CapacityPolicy {
+	bc, ok := c.Businesses[strings.ToLower(business)]
+	if !ok {
+		return capacityPolicies[POLICY_INFRA_FEE]
+	}
+	return capacityPolicies[bc.Policy]
+}
+
+// Calculate расчет ресурсов с удержанием infra_fee
+// колонны из infra_customers получают свои закупленные ресурсы и infra_fee остальных колонн,
+// остальные колонны - закупленные ресурсы за вычетом infra_fee;
+// расчет выполняется в inf.Dec, результат округляется вниз до милли-единиц
+func (infraFeePolicy) Calculate(prom MetricsQuerier, rl corev1.ResourceList, business string, calculateCfg CalculateType) (corev1.ResourceList, error) {
+	resourcesAvailable := corev1.ResourceList{}
+
+	// проверяем входит ли имя колонны в список infra_customers
+	infra := stringInSlice(business, calculateCfg.InfraCustomers)
+
+	for rname, q := range rl {
+		value := decFromQuantity(q)
+
+		if infra {
+			// если колонна входит в этот список
+			// то производится расчет ресурсов с учётом закупленных для этой колонны
+			// и процента infra_fee от остальных колонн
+			share, err := infraShare(prom, rname, calculateCfg)
+			if err != nil {
+				return rl, err
+			}
+			// часть infra_fee может быть передана в burst-пул
+			share.Mul(share, new(inf.Dec).Sub(inf.NewDec(1, 0), feeRatio(calculateCfg.BurstPoolPercent)))
+			value.Add(value, share)
+		} else {
+			// для остальных колонн удерживается процент infra_fee от закупленных ресурсов
+			keep := new(inf.Dec).Sub(inf.NewDec(1, 0), feeRatio(calculateCfg.infraFee(business, rname)))
+			value.Mul(value, keep)
+		}
+
+		// учитывается коэффициент переподписки
+		oversubscription, err := decFromFloat(calculateCfg.oversubscription(business, rname))
+		if err != nil {
+			return rl, err
+		}
+		value.Mul(value, oversubscription)
+
+		resourcesAvailable[rname], err = decToQuantity(rname, value)
+		if err != nil {
+			return rl, err
+		}
+	}
+	return resourcesAvailable, nil
+}
+
+// infraShare ресурсы, удерживаемые как infra_fee со всех колонн, не входящих в infra_customers
+// колонны с переопределенными параметрами считаются отдельно со своим infra_fee;
+// колонны с политиками fixed и fair_share infra_fee не платят и не учитываются
+func infraShare(prom MetricsQuerier, rname corev1.ResourceName, calculateCfg CalculateType) (*inf.Dec, error) {
+	share := new(inf.Dec)
+
+	rule, ok := calculateCfg.Resources[rname]
+	if !ok {
+		return share, nil
+	}
+
+	overridden := calculateCfg.overriddenBusinesses()
+
+	// колонны без переопределений
+	others, err := assetValue(prom, rule, exceptBusinessesSelector(append(overridden, calculateCfg.InfraCustomers...)))
+	if err != nil {
+		return nil, err
+	}
+	share.Add(share, others.Mul(others, feeRatio(calculateCfg.infraFee("", rname))))
+
+	// колонны с переопределенными параметрами
+	for _, business := range overridden {
+		if calculateCfg.Businesses[business].Policy != POLICY_INFRA_FEE {
+			continue
+		}
+
+		asset, err := assetValue(prom, rule, businessSelector(business))
+		if err != nil {
+			return nil, err
+		}
+		share.Add(share, asset.Mul(asset, feeRatio(calculateCfg.infraFee(business, rname))))
+	}
+
+	return share, nil
+}
+
+// assetValue закупленный ресурс по условию selector
+func assetValue(prom MetricsQuerier, rule ResourceRule, selector string) (*inf.Dec, error) {
+	query, err := executeQuery(rule.AssetQuery, selector)
+	if err != nil {
+		return nil, err
+	}
+
+	promValue, err := prom.GetValue(query)
+	if err != nil {
+		return nil, err
+	}
+
+	// промежуточное значение сохраняется с точностью до милли-единиц,
+	// итоговое значение округляется до точности ресурса в decToQuantity
+	d, err := decFromFloat(promValue)
+	if err != nil {
+		return nil, err
+	}
+	return d.Round(d, milliScale, inf.RoundFloor), nil
+}
+
+// Calculate фиксированный пул ресурсов колонны из business_overrides.<колонна>.pool
+// закупленные ресурсы не учитываются; ресурсы, которых нет в пуле, равны нулю
+func (fixedPoolPolicy) Calculate(prom MetricsQuerier, rl corev1.ResourceList, business string, calculateCfg CalculateType) (corev1.ResourceList, error) {
+	pool := calculateCfg.Businesses[strings.ToLower(business)].Pool
+
+	resourcesAvailable := corev1.ResourceList{}
+	for rname := range rl {
+		resourcesAvailable[rname] = pool[rname].DeepCopy()
+	}
+	return resourcesAvailable, nil
+}
+
+// Calculate доля общего пула колонн с политикой fair_share
+// пул - сумма закупленных ресурсов этих колонн, доля - вес колонны к сумме весов;
+// к доле применяется переподписка колонны, infra_fee не удерживается
+func (fairSharePolicy) Calculate(prom MetricsQuerier, rl corev1.ResourceList, business string, calculateCfg CalculateType) (corev1.ResourceList, error) {
+	group, totalWeight := calculateCfg.fairShareGroup()
+
+	weight, err := decFromFloat(calculateCfg.Businesses[strings.ToLower(business)].Weight)
+	if err != nil {
+		return rl, err
+	}
+	total, err := decFromFloat(totalWeight)
+	if err != nil {
+		return rl, err
+	}
+
+	resourcesAvailable := corev1.ResourceList{}
+	for rname := range rl {
+		value := new(inf.Dec)
+
+		if rule, ok := calculateCfg.Resources[rname]; ok {
+			pool, err := assetValue(prom, rule, businessSelector(strings.Join(group, "|")))
+			if err != nil {
+				return rl, err
+			}
+			value.Mul(pool, weight)
+			value.QuoRound(value, total, milliScale+3, inf.RoundDown)
+		}
+
+		oversubscription, err := decFromFloat(calculateCfg.oversubscription(business, rname))
+		if err != nil {
+			return rl, err
+		}
+		value.Mul(value, oversubscription)
+
+		resourcesAvailable[rname], err = decToQuantity(rname, value)
+		if err != nil {
+			return rl, err
+		}
+	}
+	return resourcesAvailable, nil
+}
+
+// fairShareGroup колонны с политикой fair_share и сумма их весов
+func (c CalculateType) fairShareGroup() ([]string, float64) {
+	group := []string{}
+	total := 0.0
+	for business, bc := range c.Businesses {
+		if bc.Policy == POLICY_FAIR_SHARE {
+			group = append(group, business)
+			total += bc.Weight
+		}
+	}
+	sort.Strings(group)
+	return group, total
+}
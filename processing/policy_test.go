@@ -0,0 +1,47 @@
+package processing
+
+import (
+	"testing"
+
+	"resource-manager/config"
+
+	corev1 "k8s.io/api/core/v1"
+)
+
+// TestInfraShareExcludesPolicies колонны с политиками fixed и fair_share не платят infra_fee
+func TestInfraShareExcludesPolicies(t *testing.T) {
+	c := testConfig()
+	c.InfraFee = "10"
+	c.InfraCustomers = []string{"infra"}
+	c.BusinessOverrides = map[string]config.BusinessOverrideType{
+		"custom":    {InfraFee: "20"},
+		"fair-biz":  {CapacityPolicy: POLICY_FAIR_SHARE},
+		"fixed-biz": {CapacityPolicy: POLICY_FIXED, Pool: map[string]string{"limits.cpu": "5"}},
+	}
+	s, _, prom := newTestService(t, c)
+
+	rule := s.cfg.Calculate.Resources[corev1.ResourceLimitsCPU]
+	prom.set(t, rule, true, exceptBusinessesSelector([]string{"custom", "fair-biz", "fixed-biz", "infra"}), 100)
+	prom.setAsset(t, s, "custom", corev1.ResourceLimitsCPU, 50)
+	prom.setAsset(t, s, "fair-biz", corev1.ResourceLimitsCPU, 1000)
+	prom.setAsset(t, s, "fixed-biz", corev1.ResourceLimitsCPU, 1000)
+
+	// 100 * 10% + 50 * 20%
+	share, err := infraShare(prom, corev1.ResourceLimitsCPU, s.cfg.Calculate)
+	if err != nil {
+		t.Fatal(err)
+	}
+	q, err := decToQuantity(corev1.ResourceLimitsCPU, share)
+	if err != nil {
+		t.Fatal(err)
+	}
+	assertQuantity(t, "infra share", corev1.ResourceList{corev1.ResourceLimitsCPU: q}, corev1.ResourceLimitsCPU, "20")
+
+	// доля fair_share без удержания infra_fee
+	rl := corev1.ResourceList{corev1.ResourceLimitsCPU: {}}
+	available, err := fairSharePolicy{}.Calculate(prom, rl, "fair-biz", s.cfg.Calculate)
+	if err != nil {
+		t.Fatal(err)
+	}
+	assertQuantity(t, "fair share", available, corev1.ResourceLimitsCPU, "1000")
+}
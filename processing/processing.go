@@ -4,12 +4,14 @@ import (
 	"fmt"
 	"resource-manager/config"
 	"resource-manager/kube"
-	"resource-manager/prometheus"
+	"resource-manager/resourcemath"
 	"strconv"
 	"strings"
+	"time"
 
 	jsoniter "github.com/json-iterator/go"
 
+	coordinationv1 "k8s.io/api/coordination/v1"
 	corev1 "k8s.io/api/core/v1"
 	"k8s.io/apimachinery/pkg/api/resource"
 
@@ -27,6 +29,14 @@ type CalculateType struct {
 	InfraFee            int
 	InfraCustomers      []string
 	CpuOversubscription float64
+	Resources           map[corev1.ResourceName]ResourceRule
+	Businesses          map[string]BusinessCalculateType
+	Oversubscription    map[corev1.ResourceName]float64
+	// BurstPoolPercent процент infra_fee, переданный в burst-пул
+	BurstPoolPercent int
+
+	// cpuOversubscriptionSet cpu_oversubscription задан в конфигурации
+	cpuOversubscriptionSet bool
 }
 
 type ConfigProcessing struct {
@@ -34,21 +44,120 @@ type ConfigProcessing struct {
 	Calculate                   CalculateType
 	BusinessAnnotationFieldName string
 	DefaultLimitRange           *corev1.LimitRange
+	AdmissionLock               AdmissionLockType
+	Ledger                      LedgerType
+	HardSource                  string
+	HardSourceCrossCheck        bool
+	UnmanagedResources          UnmanagedResourcesType
+	BurstPool                   BurstPoolType
+	GrantExpiry                 GrantExpiryType
+	Drift                       DriftType
 }
 
-var cfg ConfigProcessing
+// QuotaStore чтение и запись объектов ResourceQuota
+type QuotaStore interface {
+	GetQuota(name, ns string) (*corev1.ResourceQuota, error)
+	GetAllQuotas() (*corev1.ResourceQuotaList, error)
+	CreateQuota(rq *corev1.ResourceQuota, as *kube.User) (*corev1.ResourceQuota, error)
+	UpdateQuota(rq *corev1.ResourceQuota, as *kube.User) (*corev1.ResourceQuota, error)
+	DeleteQuota(rq *corev1.ResourceQuota, as *kube.User) error
+}
+
+// NamespaceStore чтение и запись неймспейсов
+type NamespaceStore interface {
+	GetNamespace(nsName string) (*corev1.Namespace, error)
+	GetNamespaces() (*corev1.NamespaceList, error)
+	UpdateNamespace(ns *corev1.Namespace) (*corev1.Namespace, error)
+}
+
+// LimitRangeStore чтение и запись объектов LimitRange
+type LimitRangeStore interface {
+	GetLimitRange(name, ns string) (*corev1.LimitRange, error)
+	CreateLimitRanges(lr *corev1.LimitRange, as *kube.User) (*corev1.LimitRange, error)
+	UpdateLimitRanges(lr *corev1.LimitRange, as *kube.User) (*corev1.LimitRange, error)
+	DeleteLimitRanges(lr *corev1.LimitRange, as *kube.User) error
+}
+
+// LeaseStore чтение и запись объектов Lease для блокировки admission_lock.type: lease
+type LeaseStore interface {
+	GetLease(name, ns string) (*coordinationv1.Lease, error)
+	CreateLease(lease *coordinationv1.Lease) (*coordinationv1.Lease, error)
+	UpdateLease(lease *coordinationv1.Lease) (*coordinationv1.Lease, error)
+}
+
+// EventRecorder запись событий kubernetes
+type EventRecorder interface {
+	RecordEvent(ref *corev1.ObjectReference, eventType, reason, message string) error
+}
+
+// MetricsQuerier запросы значений метрик; реализуется *prometheus.Client
+type MetricsQuerier interface {
+	GetValue(query string) (float64, error)
+}
+
+// Service расчет ресурсов колонн и выдача квот
+type Service struct {
+	cfg ConfigProcessing
+
+	quotas      QuotaStore
+	namespaces  NamespaceStore
+	limitRanges LimitRangeStore
+	leases      LeaseStore
+	events      EventRecorder
+	prom        MetricsQuerier
+
+	hardSources map[string]HardSource
+	locker      admissionLocker
+	burstLocker admissionLocker
+	ledger      reservationLedger
+	drift       driftCache
+	eventQueue  chan queuedEvent
+}
+
+// NewService сервис с конфигурацией c
+// хранилища объектов kubernetes реализуются *kube.Client, метрики - *prometheus.Client;
+// leases нужен только при admission_lock.type: lease, при events == nil события не записываются
+func NewService(
+	c config.ProcessingType,
+	quotas QuotaStore,
+	namespaces NamespaceStore,
+	limitRanges LimitRangeStore,
+	leases LeaseStore,
+	events EventRecorder,
+	prom MetricsQuerier,
+) (*Service, error) {
+	s := &Service{
+		quotas:      quotas,
+		namespaces:  namespaces,
+		limitRanges: limitRanges,
+		leases:      leases,
+		events:      events,
+		prom:        prom,
+		locker:      admissionLocker{locks: make(map[string]chan struct{})},
+		burstLocker: admissionLocker{locks: make(map[string]chan struct{})},
+		ledger:      reservationLedger{entries: make(map[string]*reservation)},
+		drift:       driftCache{statuses: make(map[string]*BusinessStatus)},
+		eventQueue:  make(chan queuedEvent, DEFAULT_EVENTS_QUEUE),
+	}
+	s.hardSources = newHardSources(s)
+
+	if err := s.init(c); err != nil {
+		return nil, err
+	}
+	return s, nil
+}
 
-// Init инициализация конфигурации
-func Init(c config.ProcessingType) error {
+// init инициализация конфигурации
+func (s *Service) init(c config.ProcessingType) error {
 	// convert string InfraFee from config to float64
 	if c.InfraFee != "" {
 		infraFee, err := strconv.Atoi(c.InfraFee)
 		if err != nil {
 			return err
 		}
-		cfg.Calculate.InfraFee = infraFee
+		s.cfg.Calculate.InfraFee = infraFee
 	} else {
-		cfg.Calculate.InfraFee = DEFAULT_INFRA_FEE
+		s.cfg.Calculate.InfraFee = DEFAULT_INFRA_FEE
 	}
 
 	// convert string CpuOversubscription from config to float64
@@ -57,12 +166,13 @@ func Init(c config.ProcessingType) error {
 		if err != nil {
 			return err
 		}
-		cfg.Calculate.CpuOversubscription = CpuOversubscription
+		s.cfg.Calculate.CpuOversubscription = CpuOversubscription
+		s.cfg.Calculate.cpuOversubscriptionSet = true
 	} else {
-		cfg.Calculate.CpuOversubscription = float64(DEFAULT_CPUOVERSUBSCRIPTION)
+		s.cfg.Calculate.CpuOversubscription = float64(DEFAULT_CPUOVERSUBSCRIPTION)
 	}
 
-	cfg.Calculate.InfraCustomers = c.InfraCustomers
+	s.cfg.Calculate.InfraCustomers = c.InfraCustomers
 
 	json := jsoniter.ConfigCompatibleWithStandardLibrary
 
@@ -72,23 +182,68 @@ func Init(c config.ProcessingType) error {
 		return err
 	}
 
-	cfg.DefaultLimitRange = new(corev1.LimitRange)
-	err = json.Unmarshal(b, cfg.DefaultLimitRange)
+	s.cfg.DefaultLimitRange = new(corev1.LimitRange)
+	err = json.Unmarshal(b, s.cfg.DefaultLimitRange)
 	if err != nil {
 		return err
 	}
 
-	cfg.DefaultResourceQuotaName = c.DefaultResourceQuotaName
-	if cfg.DefaultResourceQuotaName == "" {
-		cfg.DefaultResourceQuotaName = DEFAULT_RESOURCE_QUOTA_NAME
+	s.cfg.DefaultResourceQuotaName = c.DefaultResourceQuotaName
+	if s.cfg.DefaultResourceQuotaName == "" {
+		s.cfg.DefaultResourceQuotaName = DEFAULT_RESOURCE_QUOTA_NAME
 	}
 
-	cfg.BusinessAnnotationFieldName = c.BusinessAnnotationFieldName
-	if cfg.BusinessAnnotationFieldName == "" {
-		cfg.BusinessAnnotationFieldName = BUSINESS_FIELD_NAME
+	s.cfg.BusinessAnnotationFieldName = c.BusinessAnnotationFieldName
+	if s.cfg.BusinessAnnotationFieldName == "" {
+		s.cfg.BusinessAnnotationFieldName = BUSINESS_FIELD_NAME
 	}
 
-	return nil
+	err = s.initResources(c.Resources)
+	if err != nil {
+		return err
+	}
+
+	err = s.initUnmanagedResources(c.UnmanagedResources)
+	if err != nil {
+		return err
+	}
+
+	err = s.initBusinessOverrides(c.BusinessOverrides)
+	if err != nil {
+		return err
+	}
+
+	err = s.initOversubscription(c.Oversubscription)
+	if err != nil {
+		return err
+	}
+
+	err = s.initAdmissionLock(c.AdmissionLock)
+	if err != nil {
+		return err
+	}
+
+	err = s.initLedger(c.Ledger)
+	if err != nil {
+		return err
+	}
+
+	err = s.initBurstPool(c.BurstPool)
+	if err != nil {
+		return err
+	}
+
+	err = s.initGrantExpiry(c.GrantExpiry)
+	if err != nil {
+		return err
+	}
+
+	err = s.initDrift(c.Drift)
+	if err != nil {
+		return err
+	}
+
+	return s.initHardSource(c.HardSource, c.HardSourceCrossCheck)
 }
 
 // stringInSlice проверка на наличие строки в slice(в списке из строк)
@@ -126,89 +281,97 @@ func infoResourceQuota(rq *corev1.ResourceQuota) string {
 }
 
 // getResourceFromProm получение ресурсов с prometheus и формирование corev1.ResourceList
-func getResourceFromProm(queries map[corev1.ResourceName]string) (corev1.ResourceList, error) {
+func (s *Service) getResourceFromProm(queries map[corev1.ResourceName]string) (corev1.ResourceList, error) {
 	rl := make(corev1.ResourceList)
-	q := resource.Quantity{}
 
 	for rname := range queries {
-		v, err := prometheus.GetValue(queries[rname])
+		v, err := s.prom.GetValue(queries[rname])
 		if err != nil {
 			return nil, err
 		}
-		q.Set(int64(v))
-		rl[rname] = q.DeepCopy()
+		// cpu сохраняется с точностью до милли-единиц, чтобы не терять дробные ядра
+		q, err := floatToQuantity(rname, v)
+		if err != nil {
+			return nil, err
+		}
+		rl[rname] = q
 	}
 
 	return rl, nil
 }
 
 // GetResourcesAsset получение закупленных ресурсов у колонны
-func GetResourcesAsset(business string) (corev1.ResourceList, error) {
-	return getResourceFromProm(map[corev1.ResourceName]string{
-		corev1.ResourceLimitsCPU: fmt.Sprintf(
-			"sum(cap_asset_cpu_total{customer=~\"(?i:%s)\"})", business,
-		),
-		corev1.ResourceLimitsMemory: fmt.Sprintf(
-			"sum(cap_asset_memory_bytes_total{customer=~\"(?i:%s)\"})", business,
-		),
-	})
-}
-
-// SumResources сумма ресурсов rl1 + rl2
-func SumResources(rl1, rl2 corev1.ResourceList) corev1.ResourceList {
-	for rname, q := range rl1 {
-		q.Add(rl2[rname])
-		rl2[rname] = q
-
+func (s *Service) GetResourcesAsset(business string) (corev1.ResourceList, error) {
+	queries, err := s.assetQueries(businessSelector(business))
+	if err != nil {
+		return nil, err
 	}
-	return rl2
+	return s.getResourceFromProm(queries)
 }
 
-// SubResources возвращает разницу в ресурсах между rl1 и rl2
-func SubResources(rl1, rl2 corev1.ResourceList) corev1.ResourceList {
-	for rname, q := range rl1 {
-		q.Sub(rl2[rname])
-		rl2[rname] = q
+// GetResourcesHard получение установленных квот на ресурсы в кластере у колонны
+// источник квот задается в конфигурации hard_source
+func (s *Service) GetResourcesHard(business string) (corev1.ResourceList, error) {
+	rl, err := s.hardSources[s.cfg.HardSource].GetResourcesHard(business)
+	if err != nil {
+		return nil, err
+	}
 
+	if s.cfg.HardSourceCrossCheck {
+		s.crossCheckHard(business, rl)
 	}
-	return rl2
+
+	return rl, nil
 }
 
-// GetResourcesHard получение установленных квот на ресурсы в кластере у колонны
-func GetResourcesHard(business string) (corev1.ResourceList, error) {
-	return getResourceFromProm(map[corev1.ResourceName]string{
-		corev1.ResourceLimitsCPU: fmt.Sprintf(
-			"sum(cap_quote_hard_cpu_total{customer=~\"(?i:%s)\"})", business,
-		),
-		corev1.ResourceLimitsMemory: fmt.Sprintf(
-			"sum(cap_quote_hard_memory_bytes_total{customer=~\"(?i:%s)\"})", business,
-		),
-	})
+// Capacity ресурсы колонны на момент расчета
+type Capacity struct {
+	// Asset закупленные ресурсы
+	Asset corev1.ResourceList `json:"asset"`
+	// Calculated ресурсы с учетом infra_fee и переподписки
+	Calculated corev1.ResourceList `json:"calculated"`
+	// Hard установленные квоты
+	Hard corev1.ResourceList `json:"hard"`
+	// Borrowed ресурсы, взятые квотами колонны из burst-пула
+	Borrowed corev1.ResourceList `json:"borrowed,omitempty"`
+	// Available доступные ресурсы: Calculated + Borrowed - Hard
+	Available corev1.ResourceList `json:"available"`
 }
 
 // ResourceAvailable получение доступных ресурсов у колонны
-func ResourceAvailable(business string) (corev1.ResourceList, error) {
+func (s *Service) ResourceAvailable(business string) (corev1.ResourceList, error) {
+	capacity, err := s.GetCapacity(business)
+	if err != nil {
+		return nil, err
+	}
+	return capacity.Available, nil
+}
+
+// GetCapacity расчет закупленных, рассчитанных, установленных и доступных ресурсов у колонны
+func (s *Service) GetCapacity(business string) (*Capacity, error) {
 	var (
 		err error
 	)
 
 	resourcesAsset := corev1.ResourceList{}
 	resourcesHard := corev1.ResourceList{}
+	businesses := []string{business}
 
 	// если колонна входит в список infra_customers,
 	// то суммируются все ресурсы закупленные и запрошенные по колоннам из infra_customers
-	if stringInSlice(business, cfg.Calculate.InfraCustomers) {
-		for _, customer := range cfg.Calculate.InfraCustomers {
+	if stringInSlice(business, s.cfg.Calculate.InfraCustomers) {
+		businesses = s.cfg.Calculate.InfraCustomers
+		for _, customer := range s.cfg.Calculate.InfraCustomers {
 
 			// Получение закупленных ресурсов у колонны
-			resourcesAssetCustomer, err := GetResourcesAsset(customer)
+			resourcesAssetCustomer, err := s.GetResourcesAsset(customer)
 			if err != nil {
 				log.Errorf("get resources asset: %s", err)
 				return nil, err
 			}
 
 			// Получение запрошенных ресурсов в кластере у колонны
-			resourcesHardCustomer, err := GetResourcesHard(customer)
+			resourcesHardCustomer, err := s.GetResourcesHard(customer)
 			if err != nil {
 				log.Errorf("get resources hard: %s", err)
 				return nil, err
@@ -221,21 +384,21 @@ func ResourceAvailable(business string) (corev1.ResourceList, error) {
 			)
 
 			// суммирование ресурсов
-			resourcesAsset = SumResources(resourcesAsset, resourcesAssetCustomer)
+			resourcesAsset = resourcemath.Add(resourcesAsset, resourcesAssetCustomer)
 
-			resourcesHard = SumResources(resourcesHard, resourcesHardCustomer)
+			resourcesHard = resourcemath.Add(resourcesHard, resourcesHardCustomer)
 		}
 
 		// расчет для колонн не входящих в infra_customers
 	} else {
-		resourcesAsset, err = GetResourcesAsset(business)
+		resourcesAsset, err = s.GetResourcesAsset(business)
 		if err != nil {
 			log.Errorf("get resources total: %s", err)
 			return nil, err
 		}
 
 		// Получение запрошенных ресурсов в кластере у колонны
-		resourcesHard, err = GetResourcesHard(business)
+		resourcesHard, err = s.GetResourcesHard(business)
 		if err != nil {
 			log.Errorf("get resources hard: %s", err)
 			return nil, err
@@ -248,8 +411,8 @@ func ResourceAvailable(business string) (corev1.ResourceList, error) {
 		infoResourceList(resourcesAsset),
 	)
 
-	// Получение ресурсов с учетом данных из cfg.Calculate
-	resourcesCalculate, err := CalculateResources(resourcesAsset, business, cfg.Calculate)
+	// Получение ресурсов с учетом данных из s.cfg.Calculate
+	resourcesCalculate, err := CalculateResources(s.prom, resourcesAsset, business, s.cfg.Calculate)
 	if err != nil {
 		log.Errorf("calculate resources: %s", err)
 		return nil, err
@@ -267,193 +430,399 @@ func ResourceAvailable(business string) (corev1.ResourceList, error) {
 		infoResourceList(resourcesHard),
 	)
 
+	// ресурсы, взятые из burst-пула, входят в установленные квоты,
+	// поэтому добавляются к рассчитанным
+	resourcesBorrowed := corev1.ResourceList{}
+	if s.cfg.BurstPool.Enabled {
+		resourcesBorrowed, err = s.borrowedResources(businesses...)
+		if err != nil {
+			log.Errorf("get resources borrowed: %s", err)
+			return nil, err
+		}
+	}
+
 	// Получение разницы между resourcesCalculate и resourcesHard
-	resourcesDiff := SubResources(resourcesCalculate, resourcesHard)
+	// по учитываемым ресурсам
+	resourcesDiff := resourcemath.Sub(
+		resourcemath.Add(
+			resourcesCalculate,
+			resourcemath.Filter(resourcesBorrowed, resourcemath.Keys(resourcesCalculate)),
+		),
+		resourcemath.Filter(resourcesHard, resourcemath.Keys(resourcesCalculate)),
+	)
 
-	return resourcesDiff, nil
+	if negative := resourcemath.Negative(resourcesDiff); len(negative) > 0 {
+		log.Warningf(
+			"resourcesHard exceed resourcesCalculate on the business %s: %v",
+			business,
+			negative,
+		)
+	}
 
+	return &Capacity{
+		Asset:      resourcesAsset,
+		Calculated: resourcesCalculate,
+		Hard:       resourcesHard,
+		Borrowed:   resourcesBorrowed,
+		Available:  resourcesDiff,
+	}, nil
 }
 
 // IsResourcesAvailable доступны ли ресурсы у колонны
-func IsResourcesAvailable(business string, rl corev1.ResourceList) (bool, error) {
+func (s *Service) IsResourcesAvailable(business string, rl corev1.ResourceList) (bool, error) {
 
-	resourceAvailable, err := ResourceAvailable(business)
+	resourceAvailable, err := s.ResourceAvailable(business)
 	if err != nil {
 		log.Errorf("get resources available: %s", err)
 		return false, err
 	}
-	return geResource(resourceAvailable, rl), nil
+	return checkResources(resourceAvailable, rl) == nil, nil
 }
 
 // CalculateResources расчет доступных ресурсов на основе закупленных и данных в calculateCfg
-func CalculateResources(rl corev1.ResourceList, business string, calculateCfg CalculateType) (corev1.ResourceList, error) {
-	var (
-		q     resource.Quantity
-		value float64
-	)
-	resourcesAvailable := corev1.ResourceList{}
-
-	// проверяем входит ли имя колонны в список infra_customers
-	if stringInSlice(business, calculateCfg.InfraCustomers) {
-		// если колонна входит в этот список
-		// то производится расчет ресурсов с учётом закупленных для этой колонны
-		// и процента infra_fee от остальных колонн
-
-		infraCustomers := strings.Join(calculateCfg.InfraCustomers, "|")
-
-		promReqMap := map[corev1.ResourceName]string{
-			corev1.ResourceLimitsCPU: fmt.Sprintf(
-				"sum(cap_asset_cpu_total{customer!~\"(?i:%s)\"})", infraCustomers),
-			corev1.ResourceLimitsMemory: fmt.Sprintf(
-				"sum(cap_asset_memory_bytes_total{customer!~\"(?i:%s)\"})", infraCustomers),
-		}
-
-		for rname := range rl {
-			q = rl[rname]
-			promValue, err := prometheus.GetValue(promReqMap[rname])
-			if err != nil {
-				return rl, err
-			}
+// расчет выполняется политикой capacity_policy колонны, по умолчанию infraFeePolicy
+func CalculateResources(prom MetricsQuerier, rl corev1.ResourceList, business string, calculateCfg CalculateType) (corev1.ResourceList, error) {
+	return calculateCfg.policy(business).Calculate(prom, rl, business, calculateCfg)
+}
 
-			value = float64(q.Value()) + (promValue * float64(calculateCfg.InfraFee) / 100)
-			if rname == corev1.ResourceLimitsCPU {
-				value = value * calculateCfg.CpuOversubscription
-			}
-			q.Set(int64(value))
-			resourcesAvailable[rname] = q.DeepCopy()
-		}
+// geResource больше или равно rl1 >= rl2 по ресурсам из rl1
+func geResource(rl1, rl2 corev1.ResourceList) bool {
+	rl2 = resourcemath.Filter(rl2, resourcemath.Keys(rl1))
+	return len(resourcemath.Less(rl1, rl2)) == 0
+}
 
-	} else {
-		// для остальных колонн удерживается процент infra_fee от закупленных ресурсов
-		for rname := range rl {
-			q = rl[rname]
-			value = float64(q.Value()) - (float64(q.Value()) * float64(calculateCfg.InfraFee) / 100)
-
-			// для ресурса ResourceLimitsCPU учитывается коэффициент передописки
-			if rname == corev1.ResourceLimitsCPU {
-				value = value * calculateCfg.CpuOversubscription
-			}
+// AdmissionOptions параметры запроса на создание или изменение квоты
+type AdmissionOptions struct {
+	// DryRun только расчет, квота не записывается
+	DryRun bool
+	// Burst срок заема недостающих ресурсов из burst-пула; 0 - без заема
+	Burst time.Duration
+	// ExpiresAt время окончания выдачи квоты; нулевое - квота выдается без срока
+	ExpiresAt time.Time
+	// Impersonate пользователь, от имени которого записывается квота; nil - от имени сервиса
+	Impersonate *kube.User
+}
 
-			q.Set(int64(value))
-			resourcesAvailable[rname] = q.DeepCopy()
-		}
+// admit проверка ресурсов колонны для запроса квоты
+// при нехватке ресурсов и opts.Burst недостающие ресурсы берутся из burst-пула
+func (s *Service) admit(report *AdmissionReport, opts AdmissionOptions) {
+	err := checkResources(report.Available, report.Delta)
+	if err != nil && opts.Burst > 0 && report.Err == nil {
+		err = s.borrowBurst(report, err, opts.Burst)
+	}
+	if err != nil {
+		report.reject(err)
 	}
-	return resourcesAvailable, nil
 }
 
-// geResource больше или равно rl1 >= rl2
-func geResource(rl1, rl2 corev1.ResourceList) bool {
-	var q1, q2 resource.Quantity
-	for rname := range rl1 {
-		q1 = rl1[rname]
-		q2 = rl2[rname]
-		if !(q1.Value() >= q2.Value()) {
-			return false
-		}
+// checkRequest проверки запроса квоты, не зависящие от ресурсов колонны:
+// политики unmanaged_resources, срок заема из burst-пула и срок выдачи квоты;
+// при отказе записывается событие QuotaRejected (кроме dryRun)
+func (s *Service) checkRequest(rq *corev1.ResourceQuota, opts AdmissionOptions) error {
+	// ресурсы, которые не учитываются сервисом, проверяются по политикам unmanaged_resources
+	err := s.validateResources(rq.Spec.Hard)
+	if err == nil && opts.Burst > 0 {
+		err = s.checkBurst(opts.Burst)
+	}
+	if err == nil {
+		err = s.checkExpiry(opts.ExpiresAt)
+	}
+
+	if err != nil && !opts.DryRun {
+		s.recordQuotaRejected(rq, nil, err)
 	}
-	return true
+	return err
 }
 
 // CreateResourceQuota создание квоты на ресурсы
-func CreateResourceQuota(rq *corev1.ResourceQuota) error {
+// возвращает данные расчета; при opts.DryRun квота не создается,
+// а причина отказа записывается только в отчет
+func (s *Service) CreateResourceQuota(rq *corev1.ResourceQuota, opts AdmissionOptions) (*corev1.ResourceQuota, *AdmissionReport, error) {
 	if rq.Name == "" {
-		rq.Name = cfg.DefaultResourceQuotaName
+		rq.Name = s.cfg.DefaultResourceQuotaName
+	}
+
+	if err := s.checkRequest(rq, opts); err != nil {
+		return nil, nil, err
 	}
 
-	namespace, err := kube.GetNamespace(rq.Namespace)
+	namespace, err := s.namespaces.GetNamespace(rq.Namespace)
 	if err != nil {
 		log.Errorf("Get namespace: %s", err)
-		return err
+		return nil, nil, err
 	}
 
-	businessName, err := GetBusinessName(namespace)
+	businessName, err := s.GetBusinessName(namespace)
 	if err != nil {
 		log.Errorf("Get business name: %s", err)
-		return err
+		return nil, nil, err
 	}
 
-	if ok, err := IsResourcesAvailable(businessName, rq.Spec.Hard); !ok {
+	// проверка ресурсов и создание квоты выполняются под блокировкой колонны,
+	// чтобы параллельные запросы не заняли одни и те же свободные ресурсы
+	unlock, err := s.lockAdmission(businessName)
+	if err != nil {
+		log.Errorf("Lock admission: %s", err)
+		return nil, nil, err
+	}
+	defer unlock()
+
+	// burst-пул общий для всех колонн; блокируется после блокировки колонны
+	if opts.Burst > 0 {
+		unlockBurst, err := s.lockBurstPool()
 		if err != nil {
-			return err
+			log.Errorf("Lock burst pool: %s", err)
+			return nil, nil, err
 		}
-		return ErrNoResourcesAvailable
+		defer unlockBurst()
 	}
 
-	_, err = kube.CreateQuota(rq)
+	report, err := s.newAdmissionReport(businessName, rq, rq.Spec.Hard, opts.DryRun)
+	if err != nil {
+		return nil, nil, err
+	}
+
+	s.admit(report, opts)
+	report.setExpiry(opts.ExpiresAt)
+	report.observe("create")
+
+	if opts.DryRun {
+		log.Infof("Create resource quota (dry run): %s; %s", infoResourceQuota(rq), report.Verdict)
+		return nil, report, nil
+	}
+	if report.Err != nil {
+		s.recordQuotaRejected(rq, nil, report.Err)
+		return nil, report, report.Err
+	}
+
+	if err := applyBurst(rq, nil, report); err != nil {
+		return nil, report, err
+	}
+	if err := applyExpiry(rq, nil, opts.ExpiresAt); err != nil {
+		return nil, report, err
+	}
+
+	created, err := s.quotas.CreateQuota(rq, opts.Impersonate)
 	if err != nil {
 		log.Errorf("Create resource quota: %s; %v", infoResourceQuota(rq), err)
-		return err
+		return nil, report, err
 	}
+	s.ledger.add(businessName, rq.Namespace, rq.Name, rq.Spec.Hard, rq.Spec.Hard)
+	s.recordQuotaGranted(created, report)
 	log.Infof("Create resource quota: %s; OK", infoResourceQuota(rq))
-	return nil
+	return created, report, nil
 }
 
 // UpdateResourceQuota обновление квоты на ресурсы
-func UpdateResourceQuota(rq *corev1.ResourceQuota) error {
+// возвращает данные расчета; при opts.DryRun квота не изменяется,
+// а причина отказа записывается только в отчет;
+// при ошибке после чтения текущей квоты отчет содержит её hard в Current для аудита
+func (s *Service) UpdateResourceQuota(rq *corev1.ResourceQuota, opts AdmissionOptions) (*corev1.ResourceQuota, *AdmissionReport, error) {
 	if rq.Name == "" {
-		rq.Name = cfg.DefaultResourceQuotaName
-	}
-
-	currentRQ, err := GetResourceQuota(rq.Namespace)
-	if err != nil {
-		return err
+		rq.Name = s.cfg.DefaultResourceQuotaName
 	}
 
-	// является ли запрашиваемая квота больше или равна used текущей квоты
-	// иначе выход с ошибкой ErrRequestedQuotaIsLessUsed
-	resourcesDiff := make(corev1.ResourceList)
-	if !geResource(rq.Spec.Hard, currentRQ.Status.Used) {
-		return ErrRequestedQuotaIsLessUsed
+	if err := s.checkRequest(rq, opts); err != nil {
+		return nil, nil, err
 	}
 
-	namespace, err := kube.GetNamespace(rq.Namespace)
+	namespace, err := s.namespaces.GetNamespace(rq.Namespace)
 	if err != nil {
 		log.Errorf("Get namespace: %s", err)
-		return err
+		return nil, nil, err
 	}
 
-	businessName, err := GetBusinessName(namespace)
+	businessName, err := s.GetBusinessName(namespace)
 	if err != nil {
 		log.Errorf("Get business name: %s", err)
-		return err
+		return nil, nil, err
 	}
 
-	resourcesDiff = SubResources(rq.Spec.Hard, currentRQ.Spec.Hard)
+	// текущая квота читается под блокировкой колонны,
+	// чтобы разница с запрошенной квотой не устарела до записи
+	unlock, err := s.lockAdmission(businessName)
+	if err != nil {
+		log.Errorf("Lock admission: %s", err)
+		return nil, nil, err
+	}
+	defer unlock()
 
-	if ok, err := IsResourcesAvailable(businessName, resourcesDiff); !ok {
+	// burst-пул общий для всех колонн; блокируется после блокировки колонны
+	if opts.Burst > 0 {
+		unlockBurst, err := s.lockBurstPool()
 		if err != nil {
-			return err
+			log.Errorf("Lock burst pool: %s", err)
+			return nil, nil, err
 		}
-		return ErrNoResourcesAvailable
+		defer unlockBurst()
+	}
+
+	currentRQ, err := s.GetResourceQuota(rq.Namespace)
+	if err != nil {
+		return nil, nil, err
+	}
+
+	// является ли запрашиваемая квота больше или равна used текущей квоты
+	// иначе выход с ошибкой ErrRequestedQuotaIsLessUsed
+	lessUsed := !geResource(rq.Spec.Hard, currentRQ.Status.Used)
+	if lessUsed && !opts.DryRun {
+		observeAdmission(businessName, "update", ErrRequestedQuotaIsLessUsed, false)
+		s.recordQuotaRejected(rq, currentRQ, ErrRequestedQuotaIsLessUsed)
+		return nil, rejectedReport(businessName, rq, currentRQ, ErrRequestedQuotaIsLessUsed), ErrRequestedQuotaIsLessUsed
+	}
+
+	resourcesDiff := resourcemath.Sub(rq.Spec.Hard, currentRQ.Spec.Hard)
+
+	report, err := s.newAdmissionReport(businessName, rq, resourcesDiff, opts.DryRun)
+	if err != nil {
+		return nil, rejectedReport(businessName, rq, currentRQ, err), err
+	}
+	report.Current = currentRQ.Spec.Hard
+
+	if lessUsed {
+		report.reject(ErrRequestedQuotaIsLessUsed)
+	}
+	s.admit(report, opts)
+	report.setExpiry(opts.ExpiresAt)
+	report.observe("update")
+
+	if opts.DryRun {
+		log.Infof("Update resource quota (dry run): %s; %s", infoResourceQuota(rq), report.Verdict)
+		return nil, report, nil
+	}
+	if report.Err != nil {
+		s.recordQuotaRejected(rq, currentRQ, report.Err)
+		return nil, report, report.Err
+	}
+
+	// заем из burst-пула сохраняется при изменении квоты
+	if err := applyBurst(rq, currentRQ, report); err != nil {
+		return nil, report, err
+	}
+	// срок выдачи квоты сохраняется при изменении квоты
+	if err := applyExpiry(rq, currentRQ, opts.ExpiresAt); err != nil {
+		return nil, report, err
 	}
 
-	_, err = kube.UpdateQuota(rq)
+	updated, err := s.quotas.UpdateQuota(rq, opts.Impersonate)
 	if err != nil {
 		log.Errorf("Update resource quota: %s; %v", infoResourceQuota(rq), err)
-		return err
+		return nil, report, err
 	}
+	s.ledger.add(businessName, rq.Namespace, rq.Name, rq.Spec.Hard, resourcesDiff)
+	s.recordQuotaGranted(updated, report)
 	log.Infof("Update resource quota: %s; OK", infoResourceQuota(rq))
-	return nil
+	return updated, report, nil
+}
+
+// ReleasedResources ресурсы, возвращенные колонне при удалении квоты
+type ReleasedResources struct {
+	Business  string              `json:"business"`
+	Namespace string              `json:"namespace"`
+	Name      string              `json:"name"`
+	DryRun    bool                `json:"dryRun"`
+	Released  corev1.ResourceList `json:"released"`
+	// Available доступные ресурсы колонны до удаления квоты
+	Available corev1.ResourceList `json:"available,omitempty"`
 }
 
 // DeleteResourceQuota удаление квоты на ресурсы
-func DeleteResourceQuota(rq *corev1.ResourceQuota) error {
+// возвращает ресурсы, которые освобождаются у колонны;
+// при dryRun квота не удаляется; as - пользователь, от имени которого удаляется квота, nil - от имени сервиса;
+// при ошибке удаления ресурсы квоты также возвращаются для аудита
+func (s *Service) DeleteResourceQuota(rq *corev1.ResourceQuota, dryRun bool, as *kube.User) (*ReleasedResources, error) {
 	if rq.Name == "" {
-		rq.Name = cfg.DefaultResourceQuotaName
+		rq.Name = s.cfg.DefaultResourceQuotaName
+	}
+
+	namespace, err := s.namespaces.GetNamespace(rq.Namespace)
+	if err != nil {
+		log.Errorf("Get namespace: %s", err)
+		return nil, err
+	}
+
+	businessName, err := s.GetBusinessName(namespace)
+	if err != nil {
+		log.Errorf("Get business name: %s", err)
+		return nil, err
+	}
+
+	unlock, err := s.lockAdmission(businessName)
+	if err != nil {
+		log.Errorf("Lock admission: %s", err)
+		return nil, err
+	}
+	defer unlock()
+
+	currentRQ, err := s.quotas.GetQuota(rq.Name, rq.Namespace)
+	if err != nil {
+		log.Errorf("Get resource quota: %s; %v", infoResourceQuota(rq), err)
+		return nil, err
+	}
+
+	released := &ReleasedResources{
+		Business:  businessName,
+		Namespace: currentRQ.Namespace,
+		Name:      currentRQ.Name,
+		DryRun:    dryRun,
+		Released:  currentRQ.Spec.Hard.DeepCopy(),
+	}
+
+	// удаление квоты не зависит от доступных ресурсов, поэтому ошибка расчета не прерывает удаление
+	if capacity, err := s.GetCapacity(businessName); err == nil {
+		released.Available = capacity.Available
+	} else {
+		log.Errorf("get resources available: %s", err)
+	}
+
+	if dryRun {
+		log.Infof("Delete resource quota (dry run): %s; OK", infoResourceQuota(currentRQ))
+		return released, nil
 	}
-	err := kube.DeleteQuota(rq)
+
+	if err := s.deleteQuota(businessName, currentRQ, as); err != nil {
+		return released, err
+	}
+
+	return released, nil
+}
+
+// deleteQuota удаление квоты колонны business
+// вызывается под блокировкой колонны; as - пользователь, от имени которого удаляется квота
+func (s *Service) deleteQuota(businessName string, currentRQ *corev1.ResourceQuota, as *kube.User) error {
+	err := s.quotas.DeleteQuota(currentRQ, as)
 	if err != nil {
-		log.Errorf("Delete resource quota: %s; %v", infoResourceQuota(rq), err)
+		log.Errorf("Delete resource quota: %s; %v", infoResourceQuota(currentRQ), err)
 		return err
 	}
-	log.Infof("Delete resource quota: %s; OK", infoResourceQuota(rq))
+
+	// освобожденные ресурсы учитываются сразу, не дожидаясь обновления метрик:
+	// квота должна пропасть из метрик, поэтому ожидаемое значение нулевое
+	s.ledger.add(
+		businessName,
+		currentRQ.Namespace,
+		currentRQ.Name,
+		resourcemath.Zero(currentRQ.Spec.Hard),
+		resourcemath.Neg(currentRQ.Spec.Hard),
+	)
+
+	log.Infof("Delete resource quota: %s; OK", infoResourceQuota(currentRQ))
 	return nil
 }
 
+// NamespaceBusiness имя колонны по аннотации namespace ns
+func (s *Service) NamespaceBusiness(ns string) (string, error) {
+	namespace, err := s.namespaces.GetNamespace(ns)
+	if err != nil {
+		return "", err
+	}
+	return s.GetBusinessName(namespace)
+}
+
 // GetBusinessName получение имени бизнесс колонны по имени неймспейса
 // имя переводится в нижний регистр
-func GetBusinessName(namespace *corev1.Namespace) (string, error) {
-	// namespace, err := kube.GetNamespace(ns)
+func (s *Service) GetBusinessName(namespace *corev1.Namespace) (string, error) {
+	// namespace, err := s.namespaces.GetNamespace(ns)
 	// if err != nil {
 	// 	return "", err
 	// }
@@ -462,11 +831,11 @@ func GetBusinessName(namespace *corev1.Namespace) (string, error) {
 		return "", fmt.Errorf("annotation in namespace %s is empty", namespace.Name)
 	}
 
-	businessName, ok := annotaions[cfg.BusinessAnnotationFieldName]
+	businessName, ok := annotaions[s.cfg.BusinessAnnotationFieldName]
 	if !ok {
 		return "", fmt.Errorf(
 			"in the annotation, the %s field in the namespace %s is not filled",
-			cfg.BusinessAnnotationFieldName,
+			s.cfg.BusinessAnnotationFieldName,
 			namespace.Name,
 		)
 	}
@@ -475,43 +844,60 @@ func GetBusinessName(namespace *corev1.Namespace) (string, error) {
 }
 
 // GetResourceQuota получение текущей resourcequota по имени неймспейса
-func GetResourceQuota(ns string) (*corev1.ResourceQuota, error) {
-	return kube.GetQuota(cfg.DefaultResourceQuotaName, ns)
+func (s *Service) GetResourceQuota(ns string) (*corev1.ResourceQuota, error) {
+	return s.quotas.GetQuota(s.cfg.DefaultResourceQuotaName, ns)
 }
 
 // CreateLimitRanges создание LimitRange
-func CreateLimitRanges(lr *corev1.LimitRange) error {
-	_, err := kube.CreateLimitRanges(lr)
+// при as != nil LimitRange создается от имени пользователя as
+func (s *Service) CreateLimitRanges(lr *corev1.LimitRange, as *kube.User) (*corev1.LimitRange, error) {
+	created, err := s.limitRanges.CreateLimitRanges(lr, as)
 	if err != nil {
 		log.Errorf("Create limit ranges: %v", err)
 	}
-	return err
+	return created, err
 }
 
 // CreateDefaultLimitRanges создание LimitRange со значением по умолчанию
-func CreateDefaultLimitRanges(ns string) error {
-	limitRange := *cfg.DefaultLimitRange
+// возвращает запрошенный LimitRange
+func (s *Service) CreateDefaultLimitRanges(ns string, as *kube.User) (*corev1.LimitRange, error) {
+	limitRange := *s.cfg.DefaultLimitRange
 	limitRange.Namespace = ns
-	return CreateLimitRanges(&limitRange)
+	_, err := s.CreateLimitRanges(&limitRange, as)
+	return &limitRange, err
 }
 
-// UpdateLimitRanges обновление LimitRange
-func UpdateLimitRanges(lr *corev1.LimitRange) error {
-	_, err := kube.UpdateLimitRanges(lr)
+// UpdateLimitRanges обновление LimitRange от имени пользователя as
+func (s *Service) UpdateLimitRanges(lr *corev1.LimitRange, as *kube.User) (*corev1.LimitRange, error) {
+	updated, err := s.limitRanges.UpdateLimitRanges(lr, as)
 	if err != nil {
 		log.Errorf("Update limit ranges: %v", err)
 	}
-	return err
+	return updated, err
 }
 
 // DeleteLimitRanges удаление LimitRange
-func DeleteLimitRanges(lr *corev1.LimitRange) error {
+// возвращает удаляемый LimitRange; при dryRun LimitRange не удаляется;
+// as - пользователь, от имени которого удаляется LimitRange
+func (s *Service) DeleteLimitRanges(lr *corev1.LimitRange, dryRun bool, as *kube.User) (*corev1.LimitRange, error) {
 	if lr.GetName() == "" {
-		lr.Name = cfg.DefaultLimitRange.Name
+		lr.Name = s.cfg.DefaultLimitRange.Name
 	}
-	err := kube.DeleteLimitRanges(lr)
+
+	current, err := s.limitRanges.GetLimitRange(lr.Name, lr.Namespace)
+	if err != nil {
+		log.Errorf("Get limit ranges: %v", err)
+		return nil, err
+	}
+
+	if dryRun {
+		return current, nil
+	}
+
+	err = s.limitRanges.DeleteLimitRanges(current, as)
 	if err != nil {
 		log.Errorf("Delete limit ranges: %v", err)
+		return nil, err
 	}
-	return err
+	return current, nil
 }
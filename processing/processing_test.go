@@ -0,0 +1,342 @@
+package processing
+
+import (
+	"errors"
+	"sync"
+	"testing"
+
+	"resource-manager/config"
+	"resource-manager/kube"
+
+	corev1 "k8s.io/api/core/v1"
+	apierrors "k8s.io/apimachinery/pkg/api/errors"
+	"k8s.io/apimachinery/pkg/api/resource"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/runtime"
+	"k8s.io/client-go/kubernetes/fake"
+)
+
+// fakeProm MetricsQuerier со значениями по тексту запроса; неизвестные запросы возвращают 0,
+// как prometheus без данных
+type fakeProm struct {
+	mu     sync.Mutex
+	values map[string]float64
+}
+
+func (p *fakeProm) GetValue(query string) (float64, error) {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	return p.values[query], nil
+}
+
+// set значение запроса по шаблону t для колонн selector
+func (p *fakeProm) set(t *testing.T, rule ResourceRule, asset bool, selector string, v float64) {
+	t.Helper()
+
+	tmpl := rule.HardQuery
+	if asset {
+		tmpl = rule.AssetQuery
+	}
+	query, err := executeQuery(tmpl, selector)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	p.values[query] = v
+}
+
+// setAsset закупленный ресурс колонны
+func (p *fakeProm) setAsset(t *testing.T, s *Service, business string, rname corev1.ResourceName, v float64) {
+	p.set(t, s.cfg.Calculate.Resources[rname], true, businessSelector(business), v)
+}
+
+// testConfig конфигурация без infra_fee и переподписки: рассчитанные ресурсы равны закупленным,
+// установленные квоты берутся из ResourceQuota в кластере
+func testConfig() config.ProcessingType {
+	return config.ProcessingType{
+		InfraFee:            "0",
+		CpuOversubscription: "1",
+		HardSource:          HARD_SOURCE_KUBE,
+	}
+}
+
+// newTestService сервис с fake clientset, в котором созданы objects
+func newTestService(t *testing.T, c config.ProcessingType, objects ...runtime.Object) (*Service, *fake.Clientset, *fakeProm) {
+	t.Helper()
+
+	clientset := fake.NewSimpleClientset(objects...)
+	client := kube.NewClient(clientset, nil)
+	prom := &fakeProm{values: make(map[string]float64)}
+
+	s, err := NewService(c, client, client, client, client, client, prom)
+	if err != nil {
+		t.Fatalf("NewService: %s", err)
+	}
+	return s, clientset, prom
+}
+
+func testNamespace(name, business string) *corev1.Namespace {
+	return &corev1.Namespace{
+		ObjectMeta: metav1.ObjectMeta{
+			Name:        name,
+			Annotations: map[string]string{BUSINESS_FIELD_NAME: business},
+		},
+	}
+}
+
+func testQuota(ns, cpu, memory string) *corev1.ResourceQuota {
+	return &corev1.ResourceQuota{
+		ObjectMeta: metav1.ObjectMeta{Name: DEFAULT_RESOURCE_QUOTA_NAME, Namespace: ns},
+		Spec: corev1.ResourceQuotaSpec{
+			Hard: corev1.ResourceList{
+				corev1.ResourceLimitsCPU:    resource.MustParse(cpu),
+				corev1.ResourceLimitsMemory: resource.MustParse(memory),
+			},
+		},
+	}
+}
+
+// getQuota квота из fake clientset; nil, если её нет
+func getQuota(t *testing.T, s *Service, ns string) *corev1.ResourceQuota {
+	t.Helper()
+
+	rq, err := s.GetResourceQuota(ns)
+	if apierrors.IsNotFound(err) {
+		return nil
+	}
+	if err != nil {
+		t.Fatal(err)
+	}
+	return rq
+}
+
+func assertQuantity(t *testing.T, name string, rl corev1.ResourceList, rname corev1.ResourceName, want string) {
+	t.Helper()
+
+	got, ok := rl[rname]
+	if !ok {
+		t.Errorf("%s: %s is missing, want %s", name, rname, want)
+		return
+	}
+	if got.Cmp(resource.MustParse(want)) != 0 {
+		t.Errorf("%s: %s = %s, want %s", name, rname, got.String(), want)
+	}
+}
+
+// assertShortfall нехватка ровно одного ресурса rname на missing
+func assertShortfall(t *testing.T, shortfall []ResourceShortfall, rname corev1.ResourceName, missing string) {
+	t.Helper()
+
+	if len(shortfall) != 1 {
+		t.Fatalf("shortfall = %v, want one entry for %s", shortfall, rname)
+	}
+	if shortfall[0].Resource != rname {
+		t.Errorf("shortfall resource = %s, want %s", shortfall[0].Resource, rname)
+	}
+	if shortfall[0].Missing.Cmp(resource.MustParse(missing)) != 0 {
+		t.Errorf("shortfall missing = %s, want %s", shortfall[0].Missing.String(), missing)
+	}
+}
+
+// newBusiness сервис с колонной biz: неймспейсы team-a и team-b, закуплено 10 cpu и 64Gi памяти
+func newBusiness(t *testing.T, objects ...runtime.Object) (*Service, *fake.Clientset) {
+	t.Helper()
+
+	objects = append(objects, testNamespace("team-a", "biz"), testNamespace("team-b", "biz"))
+	s, clientset, prom := newTestService(t, testConfig(), objects...)
+	prom.setAsset(t, s, "biz", corev1.ResourceLimitsCPU, 10)
+	prom.setAsset(t, s, "biz", corev1.ResourceLimitsMemory, 64*1024*1024*1024)
+	return s, clientset
+}
+
+func TestCreateResourceQuota(t *testing.T) {
+	s, _ := newBusiness(t)
+
+	created, report, err := s.CreateResourceQuota(testQuota("team-a", "4", "16Gi"), AdmissionOptions{})
+	if err != nil {
+		t.Fatalf("CreateResourceQuota: %s", err)
+	}
+	if created == nil || report.Verdict != VERDICT_OK {
+		t.Fatalf("created = %v, verdict = %q", created, report.Verdict)
+	}
+	assertQuantity(t, "available", report.Available, corev1.ResourceLimitsCPU, "10")
+	assertQuantity(t, "delta", report.Delta, corev1.ResourceLimitsCPU, "4")
+
+	rq := getQuota(t, s, "team-a")
+	if rq == nil {
+		t.Fatal("quota is not created")
+	}
+	assertQuantity(t, "hard", rq.Spec.Hard, corev1.ResourceLimitsCPU, "4")
+}
+
+func TestCreateResourceQuotaNoResources(t *testing.T) {
+	s, _ := newBusiness(t, testQuota("team-b", "7", "8Gi"))
+
+	_, report, err := s.CreateResourceQuota(testQuota("team-a", "5", "8Gi"), AdmissionOptions{})
+	if !errors.Is(err, ErrNoResourcesAvailable) {
+		t.Fatalf("err = %v, want %v", err, ErrNoResourcesAvailable)
+	}
+	assertShortfall(t, Shortfall(err), corev1.ResourceLimitsCPU, "2")
+	assertShortfall(t, report.Shortfall, corev1.ResourceLimitsCPU, "2")
+	if available := report.Shortfall[0].Available; available.Cmp(resource.MustParse("3")) != 0 {
+		t.Errorf("shortfall available = %s, want 3", available.String())
+	}
+
+	if rq := getQuota(t, s, "team-a"); rq != nil {
+		t.Errorf("quota is created: %s", infoResourceQuota(rq))
+	}
+}
+
+func TestCreateResourceQuotaDryRun(t *testing.T) {
+	tests := []struct {
+		name     string
+		cpu      string
+		verdict  bool
+		shortCPU string
+	}{
+		{name: "fits", cpu: "10", verdict: true},
+		{name: "short", cpu: "12", shortCPU: "2"},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			s, _ := newBusiness(t)
+
+			created, report, err := s.CreateResourceQuota(testQuota("team-a", tt.cpu, "1Gi"), AdmissionOptions{DryRun: true})
+			if err != nil {
+				t.Fatalf("dry run returned error: %s", err)
+			}
+			if created != nil {
+				t.Errorf("dry run returned created quota")
+			}
+			if !report.DryRun {
+				t.Errorf("report.DryRun = false")
+			}
+
+			if tt.verdict {
+				if report.Err != nil || report.Verdict != VERDICT_OK {
+					t.Errorf("verdict = %q, want %q", report.Verdict, VERDICT_OK)
+				}
+			} else {
+				if !errors.Is(report.Err, ErrNoResourcesAvailable) {
+					t.Errorf("report.Err = %v, want %v", report.Err, ErrNoResourcesAvailable)
+				}
+				assertShortfall(t, report.Shortfall, corev1.ResourceLimitsCPU, tt.shortCPU)
+			}
+
+			if rq := getQuota(t, s, "team-a"); rq != nil {
+				t.Errorf("dry run created quota: %s", infoResourceQuota(rq))
+			}
+		})
+	}
+}
+
+func TestUpdateResourceQuota(t *testing.T) {
+	s, _ := newBusiness(t, testQuota("team-a", "4", "16Gi"), testQuota("team-b", "3", "8Gi"))
+
+	updated, report, err := s.UpdateResourceQuota(testQuota("team-a", "7", "16Gi"), AdmissionOptions{})
+	if err != nil {
+		t.Fatalf("UpdateResourceQuota: %s", err)
+	}
+	if updated == nil {
+		t.Fatal("updated quota is nil")
+	}
+	assertQuantity(t, "current", report.Current, corev1.ResourceLimitsCPU, "4")
+	assertQuantity(t, "delta", report.Delta, corev1.ResourceLimitsCPU, "3")
+	assertQuantity(t, "available", report.Available, corev1.ResourceLimitsCPU, "3")
+
+	// свободно 0 cpu: увеличение на 2 не помещается
+	_, report, err = s.UpdateResourceQuota(testQuota("team-a", "9", "16Gi"), AdmissionOptions{})
+	if !errors.Is(err, ErrNoResourcesAvailable) {
+		t.Fatalf("err = %v, want %v", err, ErrNoResourcesAvailable)
+	}
+	assertShortfall(t, report.Shortfall, corev1.ResourceLimitsCPU, "2")
+	assertQuantity(t, "hard after rejection", getQuota(t, s, "team-a").Spec.Hard, corev1.ResourceLimitsCPU, "7")
+
+	// уменьшение квоты не требует свободных ресурсов
+	if _, _, err := s.UpdateResourceQuota(testQuota("team-a", "1", "1Gi"), AdmissionOptions{}); err != nil {
+		t.Fatalf("shrink: %s", err)
+	}
+	assertQuantity(t, "hard after shrink", getQuota(t, s, "team-a").Spec.Hard, corev1.ResourceLimitsCPU, "1")
+}
+
+func TestUpdateResourceQuotaDryRun(t *testing.T) {
+	s, _ := newBusiness(t, testQuota("team-a", "4", "16Gi"), testQuota("team-b", "3", "8Gi"))
+
+	_, report, err := s.UpdateResourceQuota(testQuota("team-a", "9", "16Gi"), AdmissionOptions{DryRun: true})
+	if err != nil {
+		t.Fatalf("dry run returned error: %s", err)
+	}
+	if !errors.Is(report.Err, ErrNoResourcesAvailable) {
+		t.Fatalf("report.Err = %v, want %v", report.Err, ErrNoResourcesAvailable)
+	}
+	assertShortfall(t, report.Shortfall, corev1.ResourceLimitsCPU, "2")
+	assertQuantity(t, "hard after dry run", getQuota(t, s, "team-a").Spec.Hard, corev1.ResourceLimitsCPU, "4")
+}
+
+// TestUpdateResourceQuotaDryRunLessUsed нехватка ресурсов сохраняется в отчете вместе с отказом из-за used
+func TestUpdateResourceQuotaDryRunLessUsed(t *testing.T) {
+	current := testQuota("team-a", "4", "16Gi")
+	current.Status.Used = corev1.ResourceList{corev1.ResourceLimitsMemory: resource.MustParse("12Gi")}
+	s, _ := newBusiness(t, current, testQuota("team-b", "3", "8Gi"))
+
+	_, report, err := s.UpdateResourceQuota(testQuota("team-a", "9", "8Gi"), AdmissionOptions{DryRun: true})
+	if err != nil {
+		t.Fatalf("dry run returned error: %s", err)
+	}
+	if !errors.Is(report.Err, ErrRequestedQuotaIsLessUsed) {
+		t.Fatalf("report.Err = %v, want %v", report.Err, ErrRequestedQuotaIsLessUsed)
+	}
+	assertShortfall(t, report.Shortfall, corev1.ResourceLimitsCPU, "2")
+}
+
+// TestUpdateResourceQuotaLessUsedReport при отказе из-за used отчет содержит текущую квоту для аудита
+func TestUpdateResourceQuotaLessUsedReport(t *testing.T) {
+	current := testQuota("team-a", "4", "16Gi")
+	current.Status.Used = corev1.ResourceList{corev1.ResourceLimitsMemory: resource.MustParse("12Gi")}
+	s, _ := newBusiness(t, current)
+
+	_, report, err := s.UpdateResourceQuota(testQuota("team-a", "4", "8Gi"), AdmissionOptions{})
+	if !errors.Is(err, ErrRequestedQuotaIsLessUsed) {
+		t.Fatalf("err = %v, want %v", err, ErrRequestedQuotaIsLessUsed)
+	}
+	if report == nil {
+		t.Fatal("report is nil")
+	}
+	assertQuantity(t, "current", report.Current, corev1.ResourceLimitsMemory, "16Gi")
+	assertQuantity(t, "requested", report.Requested, corev1.ResourceLimitsMemory, "8Gi")
+}
+
+func TestDeleteResourceQuota(t *testing.T) {
+	s, _ := newBusiness(t, testQuota("team-a", "4", "16Gi"))
+
+	released, err := s.DeleteResourceQuota(&corev1.ResourceQuota{ObjectMeta: metav1.ObjectMeta{Namespace: "team-a"}}, true, nil)
+	if err != nil {
+		t.Fatalf("dry run: %s", err)
+	}
+	assertQuantity(t, "released", released.Released, corev1.ResourceLimitsCPU, "4")
+	assertQuantity(t, "available", released.Available, corev1.ResourceLimitsCPU, "6")
+	if getQuota(t, s, "team-a") == nil {
+		t.Fatal("dry run deleted quota")
+	}
+
+	released, err = s.DeleteResourceQuota(&corev1.ResourceQuota{ObjectMeta: metav1.ObjectMeta{Namespace: "team-a"}}, false, nil)
+	if err != nil {
+		t.Fatalf("DeleteResourceQuota: %s", err)
+	}
+	if released.Business != "biz" || released.DryRun {
+		t.Errorf("released = %+v", released)
+	}
+	if rq := getQuota(t, s, "team-a"); rq != nil {
+		t.Errorf("quota is not deleted: %s", infoResourceQuota(rq))
+	}
+
+	// освобожденные ресурсы снова доступны колонне
+	available, err := s.ResourceAvailable("biz")
+	if err != nil {
+		t.Fatal(err)
+	}
+	assertQuantity(t, "available after delete", available, corev1.ResourceLimitsCPU, "10")
+}
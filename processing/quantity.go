@@ -0,0 +1,54 @@
+package processing
+
+import (
+	"fmt"
+	"strconv"
+
+	inf "gopkg.in/inf.v0"
+	corev1 "k8s.io/api/core/v1"
+	"k8s.io/apimachinery/pkg/api/resource"
+)
+
+// milliScale точность расчета ресурсов: три знака после запятой (милли-единицы)
+const milliScale = 3
+
+// quantityScale точность значения ресурса rname: милли-единицы для cpu,
+// целые единицы для байтовых и счетных ресурсов (память, хранилище, pods, count/*)
+func quantityScale(rname corev1.ResourceName) inf.Scale {
+	switch rname {
+	case corev1.ResourceCPU, corev1.ResourceRequestsCPU, corev1.ResourceLimitsCPU:
+		return milliScale
+	}
+	return 0
+}
+
+// floatToQuantity значение ресурса rname из prometheus в resource.Quantity с округлением вниз
+// до точности ресурса, см. quantityScale
+func floatToQuantity(rname corev1.ResourceName, v float64) (resource.Quantity, error) {
+	d, err := decFromFloat(v)
+	if err != nil {
+		return resource.Quantity{}, err
+	}
+	return decToQuantity(rname, d)
+}
+
+// decFromFloat коэффициент из конфигурации в inf.Dec без потери точности записи
+func decFromFloat(v float64) (*inf.Dec, error) {
+	d, ok := new(inf.Dec).SetString(strconv.FormatFloat(v, 'f', -1, 64))
+	if !ok {
+		return nil, fmt.Errorf("invalid decimal value %v", v)
+	}
+	return d, nil
+}
+
+// decFromQuantity копия значения ресурса в inf.Dec
+func decFromQuantity(q resource.Quantity) *inf.Dec {
+	return new(inf.Dec).Set(q.AsDec())
+}
+
+// decToQuantity значение ресурса rname в resource.Quantity с округлением вниз до точности ресурса,
+// чтобы доступные ресурсы не оказались больше рассчитанных
+func decToQuantity(rname corev1.ResourceName, d *inf.Dec) (resource.Quantity, error) {
+	rounded := new(inf.Dec).Round(d, quantityScale(rname), inf.RoundFloor)
+	return resource.ParseQuantity(rounded.String())
+}
@@ -0,0 +1,112 @@
+package processing
+
+import (
+	"testing"
+
+	inf "gopkg.in/inf.v0"
+	corev1 "k8s.io/api/core/v1"
+	"k8s.io/apimachinery/pkg/api/resource"
+)
+
+func TestFloatToQuantity(t *testing.T) {
+	cpu, memory := corev1.ResourceLimitsCPU, corev1.ResourceLimitsMemory
+
+	tests := []struct {
+		name  string
+		rname corev1.ResourceName
+		in    float64
+		want  string
+	}{
+		{name: "zero", rname: cpu, in: 0, want: "0"},
+		{name: "sub-core cpu", rname: cpu, in: 0.5, want: "500m"},
+		{name: "fractional cpu", rname: cpu, in: 2.5, want: "2500m"},
+		{name: "below milli", rname: cpu, in: 0.0009, want: "0"},
+		{name: "rounded down", rname: cpu, in: 1.9999, want: "1999m"},
+		{name: "memory bytes", rname: memory, in: 68719476736, want: "64Gi"},
+		{name: "large memory bytes", rname: memory, in: 1125899906842624, want: "1Pi"},
+		{name: "odd memory bytes", rname: memory, in: 1000000000001, want: "1000000000001"},
+		{name: "fractional memory bytes", rname: memory, in: 1024.75, want: "1Ki"},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			got, err := floatToQuantity(tt.rname, tt.in)
+			if err != nil {
+				t.Fatal(err)
+			}
+			if want := resource.MustParse(tt.want); got.Cmp(want) != 0 {
+				t.Errorf("floatToQuantity(%s, %v) = %s, want %s", tt.rname, tt.in, got.String(), want.String())
+			}
+		})
+	}
+}
+
+func TestDecToQuantity(t *testing.T) {
+	tests := []struct {
+		name  string
+		rname corev1.ResourceName
+		in    string
+		want  string
+	}{
+		{name: "milli", rname: corev1.ResourceRequestsCPU, in: "0.85", want: "850m"},
+		{name: "rounded down", rname: corev1.ResourceLimitsCPU, in: "0.0017", want: "1m"},
+		{name: "negative rounded down", rname: corev1.ResourceLimitsCPU, in: "-0.0015", want: "-2m"},
+		{name: "fractional bytes", rname: corev1.ResourceLimitsMemory, in: "116823110451.2", want: "116823110451"},
+		{name: "fractional storage", rname: corev1.ResourceRequestsStorage, in: "10.999", want: "10"},
+		{name: "fractional pods", rname: corev1.ResourcePods, in: "14.45", want: "14"},
+		{name: "fractional count", rname: "count/services", in: "0.7", want: "0"},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			d, ok := new(inf.Dec).SetString(tt.in)
+			if !ok {
+				t.Fatalf("invalid decimal %s", tt.in)
+			}
+			got, err := decToQuantity(tt.rname, d)
+			if err != nil {
+				t.Fatal(err)
+			}
+			if got.String() != tt.want {
+				t.Errorf("decToQuantity(%s, %s) = %s, want %s", tt.rname, tt.in, got.String(), tt.want)
+			}
+		})
+	}
+}
+
+// TestInfraFeePolicyCalculate infra_fee 15% и переподписка cpu 1.7 для закупленных ресурсов
+func TestInfraFeePolicyCalculate(t *testing.T) {
+	c := testConfig()
+	c.InfraFee = "15"
+	c.CpuOversubscription = "1.7"
+	s, _, _ := newTestService(t, c)
+
+	tests := []struct {
+		name  string
+		rname corev1.ResourceName
+		asset string
+		want  string
+	}{
+		// 0.5 * 0.85 * 1.7 = 0.7225
+		{name: "sub-core cpu", rname: corev1.ResourceLimitsCPU, asset: "500m", want: "722m"},
+		// 2.5 * 0.85 * 1.7 = 3.6125
+		{name: "fractional cpu", rname: corev1.ResourceLimitsCPU, asset: "2.5", want: "3612m"},
+		// 10 * 0.85 * 1.7 = 14.45
+		{name: "whole cpu", rname: corev1.ResourceLimitsCPU, asset: "10", want: "14450m"},
+		// переподписка на память не распространяется: 128Gi * 0.85
+		{name: "large memory", rname: corev1.ResourceLimitsMemory, asset: "128Gi", want: "116823110451"},
+		// 1Pi + 1 байт * 0.85 = 957014920816231.25, округляется до целых байт
+		{name: "huge memory", rname: corev1.ResourceLimitsMemory, asset: "1125899906842625", want: "957014920816231"},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			rl := corev1.ResourceList{tt.rname: resource.MustParse(tt.asset)}
+			got, err := infraFeePolicy{}.Calculate(nil, rl, "biz", s.cfg.Calculate)
+			if err != nil {
+				t.Fatal(err)
+			}
+			assertQuantity(t, "calculated", got, tt.rname, tt.want)
+		})
+	}
+}
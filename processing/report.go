@@ -0,0 +1,123 @@
+package processing
+
+import (
+	"time"
+
+	"resource-manager/metrics"
+
+	corev1 "k8s.io/api/core/v1"
+
+	log "k8s.io/klog/v2"
+)
+
+// VERDICT_OK запрос квоты может быть выполнен
+const VERDICT_OK = "ok"
+
+// AdmissionReport решение по запросу квоты и данные, на основе которых оно принято
+type AdmissionReport struct {
+	Business  string `json:"business"`
+	Namespace string `json:"namespace"`
+	Name      string `json:"name"`
+	DryRun    bool   `json:"dryRun"`
+	Capacity
+	// Current текущая квота при изменении
+	Current corev1.ResourceList `json:"current,omitempty"`
+	// Requested запрошенная квота
+	Requested corev1.ResourceList `json:"requested"`
+	// Delta на сколько увеличатся установленные квоты колонны
+	Delta corev1.ResourceList `json:"delta"`
+	// Shortfall нехватка ресурсов, если их недостаточно для выдачи квоты
+	Shortfall []ResourceShortfall `json:"shortfall,omitempty"`
+	// Borrow ресурсы, которые берутся из burst-пула для выдачи квоты
+	Borrow corev1.ResourceList `json:"borrow,omitempty"`
+	// BurstExpiresAt время возврата взятых ресурсов в burst-пул
+	BurstExpiresAt *time.Time `json:"burstExpiresAt,omitempty"`
+	// BurstAvailable свободные ресурсы burst-пула
+	BurstAvailable corev1.ResourceList `json:"burstAvailable,omitempty"`
+	// ExpiresAt время окончания выдачи квоты
+	ExpiresAt *time.Time `json:"expiresAt,omitempty"`
+	// Verdict ok или текст ошибки, по которой квота не может быть выдана
+	Verdict string `json:"verdict"`
+	// Err ошибка, по которой квота не может быть выдана
+	Err error `json:"-"`
+}
+
+// newAdmissionReport расчет ресурсов колонны для запроса квоты rq
+// delta - на сколько запрос увеличивает установленные квоты колонны
+func (s *Service) newAdmissionReport(business string, rq *corev1.ResourceQuota, delta corev1.ResourceList, dryRun bool) (*AdmissionReport, error) {
+	capacity, err := s.GetCapacity(business)
+	if err != nil {
+		log.Errorf("get resources available: %s", err)
+		return nil, err
+	}
+
+	return &AdmissionReport{
+		Business:  business,
+		Namespace: rq.Namespace,
+		Name:      rq.Name,
+		DryRun:    dryRun,
+		Capacity:  *capacity,
+		Requested: rq.Spec.Hard,
+		Delta:     delta,
+		Verdict:   VERDICT_OK,
+	}, nil
+}
+
+// rejectedReport отчет по запросу rq, отклоненному без расчета ресурсов колонны;
+// сохраняет текущую квоту current, чтобы она попала в аудит
+func rejectedReport(business string, rq, current *corev1.ResourceQuota, err error) *AdmissionReport {
+	return &AdmissionReport{
+		Business:  business,
+		Namespace: rq.Namespace,
+		Name:      rq.Name,
+		Current:   current.Spec.Hard,
+		Requested: rq.Spec.Hard,
+		Verdict:   err.Error(),
+		Err:       err,
+	}
+}
+
+// setExpiry время окончания выдачи квоты; нулевое время не записывается
+func (r *AdmissionReport) setExpiry(expiresAt time.Time) {
+	if expiresAt.IsZero() {
+		return
+	}
+	r.ExpiresAt = &expiresAt
+}
+
+// observe учет решения по запросу квоты в метриках; запросы с dryRun не учитываются
+func (r *AdmissionReport) observe(operation string) {
+	if r.DryRun {
+		return
+	}
+	observeAdmission(r.Business, operation, r.Err, len(r.Borrow) > 0)
+}
+
+// observeAdmission учет решения по запросу квоты колонны в метриках
+// outcome: ok, burst (квота выдана с заемом из burst-пула) или причина отказа
+func observeAdmission(business, operation string, err error, borrowed bool) {
+	outcome := VERDICT_OK
+	switch {
+	case err != nil:
+		outcome = Reason(err)
+		if outcome == "" {
+			outcome = "error"
+		}
+	case borrowed:
+		outcome = "burst"
+	}
+	metrics.AdmissionDecisions.WithLabelValues(business, operation, outcome).Inc()
+}
+
+// reject отказ в выдаче квоты; сохраняется первая причина отказа,
+// нехватка ресурсов сохраняется отдельно, даже если отказ уже был по другой причине
+func (r *AdmissionReport) reject(err error) {
+	if shortfall := Shortfall(err); len(shortfall) > 0 && len(r.Shortfall) == 0 {
+		r.Shortfall = shortfall
+	}
+	if r.Err != nil {
+		return
+	}
+	r.Err = err
+	r.Verdict = err.Error()
+}
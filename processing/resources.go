@@ -0,0 +1,131 @@
+package processing
+
+import (
+	"bytes"
+	"fmt"
+	"strings"
+	"text/template"
+
+	"resource-manager/config"
+
+	corev1 "k8s.io/api/core/v1"
+)
+
+// ResourceRule правила учета ресурса: запросы в prometheus и параметры расчета
+type ResourceRule struct {
+	// AssetQuery запрос закупленного ресурса
+	AssetQuery *template.Template
+	// HardQuery запрос установленных квот на ресурс
+	HardQuery *template.Template
+	// InfraFeeExempt infra_fee не удерживается для ресурса
+	InfraFeeExempt bool
+}
+
+// querySelector данные для шаблонов запросов
+type querySelector struct {
+	// Selector условие на метки метрики, например customer=~"(?i:platform)"
+	Selector string
+}
+
+// defaultResources ресурсы, которые учитываются, если они не заданы в конфигурации
+var defaultResources = map[string]config.ResourceType{
+	string(corev1.ResourceLimitsCPU): {
+		AssetQuery: "sum(cap_asset_cpu_total{ {{ .Selector }} })",
+		HardQuery:  "sum(cap_quote_hard_cpu_total{ {{ .Selector }} })",
+	},
+	string(corev1.ResourceLimitsMemory): {
+		AssetQuery: "sum(cap_asset_memory_bytes_total{ {{ .Selector }} })",
+		HardQuery:  "sum(cap_quote_hard_memory_bytes_total{ {{ .Selector }} })",
+	},
+}
+
+// initResources инициализация правил учета ресурсов
+// ресурсы из конфигурации дополняют и переопределяют defaultResources
+func (s *Service) initResources(c map[string]config.ResourceType) error {
+	resources := make(map[string]config.ResourceType)
+	for rname, r := range defaultResources {
+		resources[rname] = r
+	}
+	for rname, r := range c {
+		resources[rname] = r
+	}
+
+	s.cfg.Calculate.Resources = make(map[corev1.ResourceName]ResourceRule)
+	for rname, r := range resources {
+		if r.AssetQuery == "" || r.HardQuery == "" {
+			return fmt.Errorf("resource %s: asset_query and hard_query must be set", rname)
+		}
+
+		assetQuery, err := template.New(rname + "/asset").Parse(r.AssetQuery)
+		if err != nil {
+			return fmt.Errorf("resource %s: asset_query: %s", rname, err)
+		}
+
+		hardQuery, err := template.New(rname + "/hard").Parse(r.HardQuery)
+		if err != nil {
+			return fmt.Errorf("resource %s: hard_query: %s", rname, err)
+		}
+
+		s.cfg.Calculate.Resources[corev1.ResourceName(rname)] = ResourceRule{
+			AssetQuery:     assetQuery,
+			HardQuery:      hardQuery,
+			InfraFeeExempt: r.InfraFeeExempt,
+		}
+	}
+
+	return nil
+}
+
+// businessSelector условие на метрики колонны
+func businessSelector(business string) string {
+	return fmt.Sprintf("customer=~\"(?i:%s)\"", business)
+}
+
+// exceptBusinessesSelector условие на метрики всех колонн, кроме businesses
+func exceptBusinessesSelector(businesses []string) string {
+	return fmt.Sprintf("customer!~\"(?i:%s)\"", strings.Join(businesses, "|"))
+}
+
+// quotaSelector условие на метрики квоты в неймспейсе
+func (s *Service) quotaSelector(namespace, name string) string {
+	return fmt.Sprintf(
+		"%s=\"%s\",%s=\"%s\"",
+		s.cfg.Ledger.NamespaceLabel, namespace,
+		s.cfg.Ledger.QuotaLabel, name,
+	)
+}
+
+// executeQuery формирование запроса в prometheus по шаблону
+func executeQuery(t *template.Template, selector string) (string, error) {
+	var buf bytes.Buffer
+	if err := t.Execute(&buf, querySelector{Selector: selector}); err != nil {
+		return "", err
+	}
+	return buf.String(), nil
+}
+
+// assetQueries запросы закупленных ресурсов по всем учитываемым ресурсам
+func (s *Service) assetQueries(selector string) (map[corev1.ResourceName]string, error) {
+	queries := make(map[corev1.ResourceName]string)
+	for rname, rule := range s.cfg.Calculate.Resources {
+		query, err := executeQuery(rule.AssetQuery, selector)
+		if err != nil {
+			return nil, err
+		}
+		queries[rname] = query
+	}
+	return queries, nil
+}
+
+// hardQueries запросы установленных квот по всем учитываемым ресурсам
+func (s *Service) hardQueries(selector string) (map[corev1.ResourceName]string, error) {
+	queries := make(map[corev1.ResourceName]string)
+	for rname, rule := range s.cfg.Calculate.Resources {
+		query, err := executeQuery(rule.HardQuery, selector)
+		if err != nil {
+			return nil, err
+		}
+		queries[rname] = query
+	}
+	return queries, nil
+}
@@ -0,0 +1,135 @@
+package processing
+
+import (
+	"errors"
+	"fmt"
+	"sort"
+	"strings"
+
+	"resource-manager/config"
+
+	corev1 "k8s.io/api/core/v1"
+	"k8s.io/apimachinery/pkg/api/resource"
+
+	log "k8s.io/klog/v2"
+)
+
+// политики для ресурсов, которые не учитываются сервисом
+const (
+	// POLICY_DENY ресурс запрещен в квоте
+	POLICY_DENY = "deny"
+	// POLICY_PASS ресурс передается в квоту без проверки
+	POLICY_PASS = "pass"
+	// POLICY_CAP ресурс передается в квоту, значение больше max уменьшается до max
+	POLICY_CAP = "cap"
+
+	// по умолчанию ресурсы, которые не учитываются сервисом, запрещены
+	DEFAULT_UNMANAGED_POLICY = POLICY_DENY
+)
+
+// ResourcePolicy политика для ресурса, который не учитывается сервисом
+type ResourcePolicy struct {
+	Policy string
+	Max    resource.Quantity
+}
+
+type UnmanagedResourcesType struct {
+	DefaultPolicy string
+	Resources     map[corev1.ResourceName]ResourcePolicy
+}
+
+// ResourcesNotAllowedError ресурсы в запросе квоты, запрещенные политикой
+// errors.Is(err, ErrResourcesNotAllowed) для этой ошибки возвращает true
+type ResourcesNotAllowedError struct {
+	Resources []corev1.ResourceName
+}
+
+func (e *ResourcesNotAllowedError) Error() string {
+	names := []string{}
+	for _, rname := range e.Resources {
+		names = append(names, string(rname))
+	}
+	return fmt.Sprintf("%s: %s", ErrResourcesNotAllowed, strings.Join(names, ", "))
+}
+
+func (e *ResourcesNotAllowedError) Is(target error) bool {
+	return target == ErrResourcesNotAllowed
+}
+
+// initUnmanagedResources инициализация политик для ресурсов, которые не учитываются сервисом
+func (s *Service) initUnmanagedResources(c config.UnmanagedResourcesType) error {
+	s.cfg.UnmanagedResources.DefaultPolicy = c.DefaultPolicy
+	if s.cfg.UnmanagedResources.DefaultPolicy == "" {
+		s.cfg.UnmanagedResources.DefaultPolicy = DEFAULT_UNMANAGED_POLICY
+	}
+	if p := s.cfg.UnmanagedResources.DefaultPolicy; p != POLICY_DENY && p != POLICY_PASS {
+		return fmt.Errorf("unmanaged resources: unknown default policy %q", p)
+	}
+
+	s.cfg.UnmanagedResources.Resources = make(map[corev1.ResourceName]ResourcePolicy)
+	for rname, r := range c.Resources {
+		policy := ResourcePolicy{Policy: r.Policy}
+
+		switch r.Policy {
+		case POLICY_DENY, POLICY_PASS:
+		case POLICY_CAP:
+			max, err := resource.ParseQuantity(r.Max)
+			if err != nil {
+				return fmt.Errorf("unmanaged resource %s: max: %s", rname, err)
+			}
+			policy.Max = max
+		default:
+			return fmt.Errorf("unmanaged resource %s: unknown policy %q", rname, r.Policy)
+		}
+
+		s.cfg.UnmanagedResources.Resources[corev1.ResourceName(rname)] = policy
+	}
+
+	return nil
+}
+
+// validateResources проверка ресурсов запроса квоты, которые не учитываются сервисом
+// значения ресурсов с политикой cap, превышающие max, уменьшаются до max прямо в rl;
+// возвращает *ResourcesNotAllowedError со списком ресурсов, запрещенных политикой
+func (s *Service) validateResources(rl corev1.ResourceList) error {
+	denied := []corev1.ResourceName{}
+
+	for rname, q := range rl {
+		if _, ok := s.cfg.Calculate.Resources[rname]; ok {
+			continue
+		}
+
+		policy, ok := s.cfg.UnmanagedResources.Resources[rname]
+		if !ok {
+			policy.Policy = s.cfg.UnmanagedResources.DefaultPolicy
+		}
+
+		switch policy.Policy {
+		case POLICY_PASS:
+			continue
+		case POLICY_CAP:
+			if q.Cmp(policy.Max) > 0 {
+				log.Infof("Unmanaged resources: %s %s capped to %s", rname, q.String(), policy.Max.String())
+				rl[rname] = policy.Max.DeepCopy()
+			}
+			continue
+		}
+		denied = append(denied, rname)
+	}
+
+	if len(denied) == 0 {
+		return nil
+	}
+
+	sort.Slice(denied, func(i, j int) bool { return denied[i] < denied[j] })
+	return &ResourcesNotAllowedError{Resources: denied}
+}
+
+// NotAllowedResources ресурсы из ошибки; nil, если ошибка не о запрещенных ресурсах
+func NotAllowedResources(err error) []corev1.ResourceName {
+	var e *ResourcesNotAllowedError
+	if errors.As(err, &e) {
+		return e.Resources
+	}
+	return nil
+}
@@ -0,0 +1,84 @@
+package processing
+
+import (
+	"errors"
+	"reflect"
+	"testing"
+
+	"resource-manager/config"
+
+	corev1 "k8s.io/api/core/v1"
+	"k8s.io/apimachinery/pkg/api/resource"
+)
+
+func TestValidateResources(t *testing.T) {
+	policies := map[string]config.ResourcePolicyType{
+		"pods":     {Policy: POLICY_CAP, Max: "200"},
+		"services": {Policy: POLICY_DENY},
+	}
+
+	tests := []struct {
+		name          string
+		defaultPolicy string
+		hard          corev1.ResourceList
+		want          corev1.ResourceList
+		denied        []corev1.ResourceName
+	}{
+		{
+			name:   "default deny",
+			hard:   corev1.ResourceList{"configmaps": resource.MustParse("1000")},
+			denied: []corev1.ResourceName{"configmaps"},
+		},
+		{
+			name:          "default pass",
+			defaultPolicy: POLICY_PASS,
+			hard:          corev1.ResourceList{"configmaps": resource.MustParse("1000")},
+			want:          corev1.ResourceList{"configmaps": resource.MustParse("1000")},
+		},
+		{
+			name: "managed resource is not checked",
+			hard: corev1.ResourceList{corev1.ResourceLimitsCPU: resource.MustParse("1000")},
+			want: corev1.ResourceList{corev1.ResourceLimitsCPU: resource.MustParse("1000")},
+		},
+		{
+			name: "cap below max",
+			hard: corev1.ResourceList{corev1.ResourcePods: resource.MustParse("100")},
+			want: corev1.ResourceList{corev1.ResourcePods: resource.MustParse("100")},
+		},
+		{
+			name: "cap above max is clamped",
+			hard: corev1.ResourceList{corev1.ResourcePods: resource.MustParse("500")},
+			want: corev1.ResourceList{corev1.ResourcePods: resource.MustParse("200")},
+		},
+		{
+			name:   "deny",
+			hard:   corev1.ResourceList{corev1.ResourceServices: resource.MustParse("1"), corev1.ResourcePods: resource.MustParse("500")},
+			denied: []corev1.ResourceName{corev1.ResourceServices},
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			c := testConfig()
+			c.UnmanagedResources = config.UnmanagedResourcesType{DefaultPolicy: tt.defaultPolicy, Resources: policies}
+			s, _, _ := newTestService(t, c)
+
+			err := s.validateResources(tt.hard)
+			if tt.denied != nil {
+				if !errors.Is(err, ErrResourcesNotAllowed) {
+					t.Fatalf("error = %v, want %s", err, ErrResourcesNotAllowed)
+				}
+				if got := NotAllowedResources(err); !reflect.DeepEqual(got, tt.denied) {
+					t.Errorf("denied = %v, want %v", got, tt.denied)
+				}
+				return
+			}
+			if err != nil {
+				t.Fatal(err)
+			}
+			for rname, q := range tt.want {
+				assertQuantity(t, "hard", tt.hard, rname, q.String())
+			}
+		})
+	}
+}
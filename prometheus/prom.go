@@ -3,6 +3,7 @@ package prometheus
 import (
 	"context"
 	"resource-manager/config"
+	"resource-manager/metrics"
 	"time"
 
 	log "k8s.io/klog/v2"
@@ -12,26 +13,41 @@ import (
 	"github.com/prometheus/common/model"
 )
 
-var client api.Client
+// Client клиент prometheus
+type Client struct {
+	api v1.API
+}
+
+// NewClient клиент для api (в тестах - fake реализация v1.API)
+func NewClient(promAPI v1.API) *Client {
+	return &Client{api: promAPI}
+}
 
-func Init(cfg config.PrometheusType) error {
-	var err error
-	client, err = api.NewClient(api.Config{
+// New клиент prometheus по адресу из конфигурации
+func New(cfg config.PrometheusType) (*Client, error) {
+	client, err := api.NewClient(api.Config{
 		Address: cfg.Address,
 	})
 	if err != nil {
-		return err
+		return nil, err
 	}
-	return nil
+	return NewClient(v1.NewAPI(client)), nil
 }
 
-func getVector(req string) (model.Vector, error) {
-	v1api := v1.NewAPI(client)
+func (c *Client) getVector(req string) (model.Vector, error) {
 	ctx, cancel := context.WithTimeout(context.Background(), 10*time.Second)
 	defer cancel()
 
-	result, warnings, err := v1api.Query(ctx, req, time.Now())
+	return c.getVectorContext(ctx, req)
+}
+
+// getVectorContext запрос вектора с ограничением по ctx
+func (c *Client) getVectorContext(ctx context.Context, req string) (model.Vector, error) {
+	start := time.Now()
+	result, warnings, err := c.api.Query(ctx, req, start)
+	metrics.PrometheusQueryDuration.Observe(time.Since(start).Seconds())
 	if err != nil {
+		metrics.PrometheusQueryErrors.Inc()
 		return nil, err
 	}
 
@@ -42,8 +58,14 @@ func getVector(req string) (model.Vector, error) {
 	return result.(model.Vector), nil
 }
 
-func GetValue(req string) (float64, error) {
-	vector, err := getVector(req)
+// Ping проверка доступности prometheus простым запросом с ограничением по ctx
+func (c *Client) Ping(ctx context.Context) error {
+	_, err := c.getVectorContext(ctx, "vector(1)")
+	return err
+}
+
+func (c *Client) GetValue(req string) (float64, error) {
+	vector, err := c.getVector(req)
 	if err != nil {
 		return 0, err
 	}
@@ -0,0 +1,162 @@
+// Package resourcemath арифметика над corev1.ResourceList
+// функции не изменяют аргументы и работают по объединению ресурсов из обоих списков;
+// отсутствующий в списке ресурс считается равным нулю
+package resourcemath
+
+import (
+	"sort"
+
+	corev1 "k8s.io/api/core/v1"
+	"k8s.io/apimachinery/pkg/api/resource"
+)
+
+// Keys отсортированный список ресурсов из объединения списков
+func Keys(rls ...corev1.ResourceList) []corev1.ResourceName {
+	set := make(map[corev1.ResourceName]bool)
+	for _, rl := range rls {
+		for rname := range rl {
+			set[rname] = true
+		}
+	}
+
+	keys := make([]corev1.ResourceName, 0, len(set))
+	for rname := range set {
+		keys = append(keys, rname)
+	}
+	sort.Slice(keys, func(i, j int) bool { return keys[i] < keys[j] })
+	return keys
+}
+
+// Add сумма rl1 + rl2
+func Add(rl1, rl2 corev1.ResourceList) corev1.ResourceList {
+	result := corev1.ResourceList{}
+	for _, rname := range Keys(rl1, rl2) {
+		q := get(rl1, rname)
+		q.Add(get(rl2, rname))
+		result[rname] = q
+	}
+	return result
+}
+
+// Sum сумма всех списков
+func Sum(rls ...corev1.ResourceList) corev1.ResourceList {
+	result := corev1.ResourceList{}
+	for _, rl := range rls {
+		result = Add(result, rl)
+	}
+	return result
+}
+
+// Sub разница rl1 - rl2
+func Sub(rl1, rl2 corev1.ResourceList) corev1.ResourceList {
+	result := corev1.ResourceList{}
+	for _, rname := range Keys(rl1, rl2) {
+		q := get(rl1, rname)
+		q.Sub(get(rl2, rname))
+		result[rname] = q
+	}
+	return result
+}
+
+// Neg список с противоположными значениями
+func Neg(rl corev1.ResourceList) corev1.ResourceList {
+	result := corev1.ResourceList{}
+	for rname := range rl {
+		q := get(rl, rname)
+		q.Neg()
+		result[rname] = q
+	}
+	return result
+}
+
+// Zero список с теми же ресурсами и нулевыми значениями
+func Zero(rl corev1.ResourceList) corev1.ResourceList {
+	result := corev1.ResourceList{}
+	for rname := range rl {
+		result[rname] = resource.Quantity{}
+	}
+	return result
+}
+
+// Min минимум по каждому ресурсу
+func Min(rl1, rl2 corev1.ResourceList) corev1.ResourceList {
+	result := corev1.ResourceList{}
+	for _, rname := range Keys(rl1, rl2) {
+		q1, q2 := get(rl1, rname), get(rl2, rname)
+		if q1.Cmp(q2) <= 0 {
+			result[rname] = q1
+		} else {
+			result[rname] = q2
+		}
+	}
+	return result
+}
+
+// Max максимум по каждому ресурсу
+func Max(rl1, rl2 corev1.ResourceList) corev1.ResourceList {
+	result := corev1.ResourceList{}
+	for _, rname := range Keys(rl1, rl2) {
+		q1, q2 := get(rl1, rname), get(rl2, rname)
+		if q1.Cmp(q2) >= 0 {
+			result[rname] = q1
+		} else {
+			result[rname] = q2
+		}
+	}
+	return result
+}
+
+// Compare сравнение по каждому ресурсу:
+// -1, если rl1 < rl2; 0, если rl1 == rl2; +1, если rl1 > rl2
+func Compare(rl1, rl2 corev1.ResourceList) map[corev1.ResourceName]int {
+	result := make(map[corev1.ResourceName]int)
+	for _, rname := range Keys(rl1, rl2) {
+		q := get(rl1, rname)
+		result[rname] = q.Cmp(get(rl2, rname))
+	}
+	return result
+}
+
+// Less ресурсы, по которым rl1 < rl2
+func Less(rl1, rl2 corev1.ResourceList) []corev1.ResourceName {
+	less := []corev1.ResourceName{}
+	for _, rname := range Keys(rl1, rl2) {
+		q := get(rl1, rname)
+		if q.Cmp(get(rl2, rname)) < 0 {
+			less = append(less, rname)
+		}
+	}
+	return less
+}
+
+// Negative ресурсы с отрицательным значением
+func Negative(rl corev1.ResourceList) []corev1.ResourceName {
+	negative := []corev1.ResourceName{}
+	for _, rname := range Keys(rl) {
+		q := get(rl, rname)
+		if q.Sign() < 0 {
+			negative = append(negative, rname)
+		}
+	}
+	return negative
+}
+
+// Filter список только с ресурсами из keys
+func Filter(rl corev1.ResourceList, keys []corev1.ResourceName) corev1.ResourceList {
+	result := corev1.ResourceList{}
+	for _, rname := range keys {
+		if q, ok := rl[rname]; ok {
+			result[rname] = q.DeepCopy()
+		}
+	}
+	return result
+}
+
+// get копия значения ресурса; нулевое значение, если ресурса нет в списке
+func get(rl corev1.ResourceList, rname corev1.ResourceName) resource.Quantity {
+	q, ok := rl[rname]
+	if !ok {
+		return resource.Quantity{}
+	}
+	return q.DeepCopy()
+}
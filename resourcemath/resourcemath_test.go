@@ -0,0 +1,156 @@
+package resourcemath
+
+import (
+	"reflect"
+	"testing"
+
+	corev1 "k8s.io/api/core/v1"
+	"k8s.io/apimachinery/pkg/api/resource"
+)
+
+const (
+	cpu    = corev1.ResourceLimitsCPU
+	memory = corev1.ResourceLimitsMemory
+	pods   = corev1.ResourcePods
+)
+
+// list список ресурсов из пар имя-значение
+func list(pairs ...string) corev1.ResourceList {
+	rl := corev1.ResourceList{}
+	for i := 0; i < len(pairs); i += 2 {
+		rl[corev1.ResourceName(pairs[i])] = resource.MustParse(pairs[i+1])
+	}
+	return rl
+}
+
+// assertList сравнение списков по значениям и набору ресурсов
+func assertList(t *testing.T, got, want corev1.ResourceList) {
+	t.Helper()
+
+	if len(got) != len(want) {
+		t.Fatalf("got %v, want %v", got, want)
+	}
+	for rname, q := range want {
+		g, ok := got[rname]
+		if !ok || g.Cmp(q) != 0 {
+			t.Errorf("%s = %s, want %s", rname, g.String(), q.String())
+		}
+	}
+}
+
+func TestKeys(t *testing.T) {
+	got := Keys(list("b", "1"), list("a", "1", "b", "2"), nil)
+	want := []corev1.ResourceName{"a", "b"}
+	if !reflect.DeepEqual(got, want) {
+		t.Errorf("Keys = %v, want %v", got, want)
+	}
+}
+
+func TestBinary(t *testing.T) {
+	rl1 := list(string(cpu), "2", string(memory), "1Gi")
+	rl2 := list(string(cpu), "500m", string(pods), "10")
+
+	tests := []struct {
+		name string
+		fn   func(rl1, rl2 corev1.ResourceList) corev1.ResourceList
+		want corev1.ResourceList
+	}{
+		{name: "Add", fn: Add, want: list(string(cpu), "2500m", string(memory), "1Gi", string(pods), "10")},
+		{name: "Sub", fn: Sub, want: list(string(cpu), "1500m", string(memory), "1Gi", string(pods), "-10")},
+		{name: "Min", fn: Min, want: list(string(cpu), "500m", string(memory), "0", string(pods), "0")},
+		{name: "Max", fn: Max, want: list(string(cpu), "2", string(memory), "1Gi", string(pods), "10")},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			in1, in2 := rl1.DeepCopy(), rl2.DeepCopy()
+
+			got := tt.fn(in1, in2)
+			assertList(t, got, tt.want)
+
+			// аргументы не изменяются, результат не разделяет с ними значения
+			assertList(t, in1, rl1)
+			assertList(t, in2, rl2)
+			for rname, q := range got {
+				q.Add(resource.MustParse("1"))
+				got[rname] = q
+			}
+			assertList(t, in1, rl1)
+			assertList(t, in2, rl2)
+		})
+	}
+}
+
+func TestSum(t *testing.T) {
+	got := Sum(list(string(cpu), "1"), list(string(memory), "1Gi"), list(string(cpu), "250m"))
+	assertList(t, got, list(string(cpu), "1250m", string(memory), "1Gi"))
+
+	assertList(t, Sum(), corev1.ResourceList{})
+}
+
+func TestNeg(t *testing.T) {
+	rl := list(string(cpu), "500m", string(pods), "-2")
+	got := Neg(rl)
+
+	assertList(t, got, list(string(cpu), "-500m", string(pods), "2"))
+	assertList(t, rl, list(string(cpu), "500m", string(pods), "-2"))
+}
+
+func TestZero(t *testing.T) {
+	rl := list(string(cpu), "500m", string(memory), "1Gi")
+	got := Zero(rl)
+
+	assertList(t, got, list(string(cpu), "0", string(memory), "0"))
+	assertList(t, rl, list(string(cpu), "500m", string(memory), "1Gi"))
+}
+
+func TestCompare(t *testing.T) {
+	got := Compare(
+		list(string(cpu), "1", string(memory), "1Gi"),
+		list(string(cpu), "1000m", string(pods), "1"),
+	)
+	want := map[corev1.ResourceName]int{cpu: 0, memory: 1, pods: -1}
+	if !reflect.DeepEqual(got, want) {
+		t.Errorf("Compare = %v, want %v", got, want)
+	}
+}
+
+func TestLess(t *testing.T) {
+	tests := []struct {
+		name     string
+		rl1, rl2 corev1.ResourceList
+		want     []corev1.ResourceName
+	}{
+		{name: "equal", rl1: list(string(cpu), "1"), rl2: list(string(cpu), "1"), want: []corev1.ResourceName{}},
+		{name: "less", rl1: list(string(cpu), "500m"), rl2: list(string(cpu), "1"), want: []corev1.ResourceName{cpu}},
+		{name: "only in second", rl1: list(string(cpu), "1"), rl2: list(string(memory), "1Gi"), want: []corev1.ResourceName{memory}},
+		{name: "only in first", rl1: list(string(memory), "1Gi"), rl2: corev1.ResourceList{}, want: []corev1.ResourceName{}},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if got := Less(tt.rl1, tt.rl2); !reflect.DeepEqual(got, tt.want) {
+				t.Errorf("Less = %v, want %v", got, tt.want)
+			}
+		})
+	}
+}
+
+func TestNegative(t *testing.T) {
+	got := Negative(list(string(cpu), "-1m", string(memory), "0", string(pods), "-3"))
+	want := []corev1.ResourceName{cpu, pods}
+	if !reflect.DeepEqual(got, want) {
+		t.Errorf("Negative = %v, want %v", got, want)
+	}
+}
+
+func TestFilter(t *testing.T) {
+	rl := list(string(cpu), "1", string(memory), "1Gi")
+	got := Filter(rl, []corev1.ResourceName{cpu, pods})
+	assertList(t, got, list(string(cpu), "1"))
+
+	q := got[cpu]
+	q.Add(resource.MustParse("1"))
+	got[cpu] = q
+	assertList(t, rl, list(string(cpu), "1", string(memory), "1Gi"))
+}